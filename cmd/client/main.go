@@ -1,8 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
 	"clauded-client/src"
 
@@ -25,9 +30,16 @@ func MakeMainCmd() *cobra.Command {
 		codeCmd            string
 		remote             string
 		flags              string
+		token              string
 		envVars            []string
-		attachPorts        []int
+		attachPorts        []string
+		publicPorts        []int
+		tcpPorts           []int
 		autoExit           bool
+		watchPorts         bool
+		autoAttach         bool
+		idleTimeout        time.Duration
+		permissionPrompts  bool
 		insecureSkipVerify bool
 		skipInstall        bool
 		daemon             bool
@@ -40,7 +52,7 @@ func MakeMainCmd() *cobra.Command {
 through gotty and piko services to a remote server, allowing you to access and use
 Claude Code from anywhere via a web browser.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runServe(session, password, authName, codeCmd, remote, flags, envVars, attachPorts, autoExit, insecureSkipVerify, skipInstall, daemon)
+			return runServe(session, password, authName, codeCmd, remote, flags, token, envVars, attachPorts, publicPorts, tcpPorts, autoExit, watchPorts, autoAttach, idleTimeout, permissionPrompts, insecureSkipVerify, skipInstall, daemon)
 		},
 	}
 
@@ -51,9 +63,16 @@ Claude Code from anywhere via a web browser.`,
 	rootCmd.Flags().StringVar(&authName, "auth-name", "session", "Auth name for http_auth key (default: session)")
 	rootCmd.Flags().StringVar(&codeCmd, "codecmd", "claude", "AI command tool to use (claude, opencode, kimi, gemini)")
 	rootCmd.Flags().StringVar(&flags, "flags", "", "Flags to pass to codecmd (e.g., '--model opus')")
+	rootCmd.Flags().StringVar(&token, "token", os.Getenv("PIKO_TOKEN"), "Piko upstream token, shared secret or signed JWT (env: PIKO_TOKEN)")
 	rootCmd.Flags().StringArrayVar(&envVars, "env", []string{}, "Environment variables to pass (e.g., -e KEY=value)")
-	rootCmd.Flags().IntSliceVar(&attachPorts, "attach-ports", []int{}, "Additional local ports to forward (e.g., --attach-ports 3000 --attach-ports 8080)")
+	rootCmd.Flags().StringSliceVar(&attachPorts, "attach-ports", []string{}, "Additional local ports to forward, optionally named (e.g., --attach-ports web=3000 --attach-ports 8080)")
+	rootCmd.Flags().IntSliceVar(&tcpPorts, "attach-tcp", []int{}, "Local ports to forward as raw TCP, reached with 'clauded connect' (e.g., --attach-tcp 5432)")
+	rootCmd.Flags().IntSliceVar(&publicPorts, "public-ports", []int{}, "Attached ports served without authentication (e.g., --public-ports 3000)")
 	rootCmd.Flags().BoolVar(&autoExit, "auto-exit", true, "Enable 2-day auto exit (default: true)")
+	rootCmd.Flags().BoolVar(&watchPorts, "watch-ports", true, "Notify when the agent starts listening on a new port, Linux only (default: true)")
+	rootCmd.Flags().BoolVar(&autoAttach, "auto-attach", false, "Attach ports opened by the agent automatically (default: false)")
+	rootCmd.Flags().DurationVar(&idleTimeout, "idle-timeout", 30*time.Second, "Send waiting_for_input after the agent has been quiet this long, 0 disables (requires tmux)")
+	rootCmd.Flags().BoolVar(&permissionPrompts, "permission-prompts", true, "Publish tool approval prompts as permission_request and answer them from notifications (requires tmux, default: true)")
 	rootCmd.Flags().BoolVar(&insecureSkipVerify, "insecure-skip-verify", false, "Skip HTTPS certificate verification (default: false)")
 	rootCmd.Flags().BoolVar(&skipInstall, "skip-install-check", false, "Skip claude-code installation check (default: false)")
 	rootCmd.Flags().BoolVarP(&daemon, "daemon", "d", true, "Run as daemon in background (default: true)")
@@ -97,10 +116,167 @@ Claude Code from anywhere via a web browser.`,
 	}
 	sessionCmd.AddCommand(killAllCmd)
 
+	// Subcommand: attach-port
+	var attachTCP, attachPublic bool
+	attachPortCmd := &cobra.Command{
+		Use:   "attach-port <session_id> <port|name=port>",
+		Short: "Start forwarding a local port in a running session",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return src.AttachPort(args[0], args[1], attachTCP, attachPublic)
+		},
+	}
+	attachPortCmd.Flags().BoolVar(&attachTCP, "tcp", false, "Forward as raw TCP, like --attach-tcp")
+	attachPortCmd.Flags().BoolVar(&attachPublic, "public", false, "Serve without authentication, like --public-ports")
+	sessionCmd.AddCommand(attachPortCmd)
+
+	// Subcommand: detach-port
+	detachPortCmd := &cobra.Command{
+		Use:   "detach-port <session_id> <port>",
+		Short: "Stop forwarding a port in a running session",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			port, err := strconv.Atoi(args[1])
+			if err != nil {
+				return fmt.Errorf("invalid port %q", args[1])
+			}
+			return src.DetachPort(args[0], port)
+		},
+	}
+	sessionCmd.AddCommand(detachPortCmd)
+
+	rootCmd.AddCommand(makeConnectCmd())
+	rootCmd.AddCommand(makeHookCmd())
+	rootCmd.AddCommand(makeNotifyCmd())
+	rootCmd.AddCommand(makeDetectCmd())
+
 	return rootCmd
 }
 
-func runServe(session, password, authName, codeCmd, remote, flags string, envVars []string, attachPorts []int, autoExit, insecureSkipVerify, skipInstall, daemon bool) error {
+// makeConnectCmd builds "clauded connect", a local tunnel to a port attached with --attach-tcp
+func makeConnectCmd() *cobra.Command {
+	var (
+		opts      src.ConnectOptions
+		localPort int
+	)
+
+	connectCmd := &cobra.Command{
+		Use:   "connect <session> <port>",
+		Short: "Open a local listener tunnelled to a session's TCP port",
+		Example: `  clauded connect abc12 5432 --remote myserver.com --password mypass --local 15432
+  psql -h 127.0.0.1 -p 15432`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			port, err := strconv.Atoi(args[1])
+			if err != nil || port <= 0 || port > 65535 {
+				return fmt.Errorf("invalid port %q", args[1])
+			}
+			if opts.Remote == "" {
+				opts.Remote = "https://clauded.friddle.me"
+			}
+			if localPort == 0 {
+				localPort = port
+			}
+			opts.Session = args[0]
+			opts.Port = port
+			opts.LocalAddr = fmt.Sprintf("127.0.0.1:%d", localPort)
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			return src.Connect(ctx, opts)
+		},
+	}
+
+	connectCmd.Flags().StringVar(&opts.Remote, "remote", "", "Remote server address (default: https://clauded.friddle.me)")
+	connectCmd.Flags().IntVar(&localPort, "local", 0, "Local port to listen on (default: same as the remote port)")
+	connectCmd.Flags().StringVar(&opts.Password, "password", os.Getenv("CLAUDED_PASSWORD"), "Session password (env: CLAUDED_PASSWORD)")
+	connectCmd.Flags().StringVar(&opts.AuthName, "auth-name", "session", "Auth name of the session")
+	connectCmd.Flags().StringVar(&opts.Token, "session-token", os.Getenv("CLAUDED_SESSION_TOKEN"), "Session token, used instead of the password (env: CLAUDED_SESSION_TOKEN)")
+	connectCmd.Flags().BoolVar(&opts.InsecureSkipVerify, "insecure-skip-verify", false, "Skip HTTPS certificate verification (default: false)")
+
+	return connectCmd
+}
+
+// makeHookCmd builds "clauded hook", the command clauded installs as Claude Code's
+// Stop, SubagentStop and Notification hook
+func makeHookCmd() *cobra.Command {
+	var sessionID string
+
+	hookCmd := &cobra.Command{
+		Use:   "hook --session <session_id>",
+		Short: "Forward a Claude Code hook event (read from stdin) to a running session",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Never fail the agent's turn, report problems on stderr only
+			if err := src.RunHook(sessionID, os.Stdin); err != nil {
+				fmt.Fprintf(os.Stderr, "clauded hook: %v\n", err)
+			}
+			return nil
+		},
+	}
+
+	hookCmd.Flags().StringVar(&sessionID, "session", "", "Session ID the event belongs to")
+
+	return hookCmd
+}
+
+// makeNotifyCmd builds "clauded notify", which publishes a notification for a running session
+func makeNotifyCmd() *cobra.Command {
+	var opts src.NotifyOptions
+
+	notifyCmd := &cobra.Command{
+		Use:   "notify",
+		Short: "Publish a notification for a running session",
+		Example: `  # inside a session (the session is detected)
+  make test && clauded notify --title "Tests passed" || clauded notify --type error --title "Tests failed"
+
+  # anywhere else
+  clauded notify --session abc12 --type progress --body "Deploy 50%" --data percentage=50`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return src.Notify(opts)
+		},
+	}
+
+	notifyCmd.Flags().StringVar(&opts.Session, "session", "", "Session ID (default: the session this runs in)")
+	notifyCmd.Flags().StringVar(&opts.Type, "type", "task_completed", "Notification type (task_completed, error, progress, system_status or custom)")
+	notifyCmd.Flags().StringVar(&opts.Title, "title", "", "Notification title")
+	notifyCmd.Flags().StringVar(&opts.Body, "body", "", "Notification body")
+	notifyCmd.Flags().StringArrayVar(&opts.Data, "data", []string{}, "Extra data fields (e.g., --data branch=main)")
+
+	return notifyCmd
+}
+
+// makeDetectCmd builds "clauded detect", which checks the output detection rules
+func makeDetectCmd() *cobra.Command {
+	var (
+		codeCmd    string
+		rulesFiles []string
+	)
+
+	detectCmd := &cobra.Command{
+		Use:   "detect",
+		Short: "Check the rules that detect completions and errors in the terminal output",
+	}
+
+	testCmd := &cobra.Command{
+		Use:   "test <file|->",
+		Short: "Dry-run the detection rules against captured output",
+		Example: `  tmux capture-pane -p -S -1000 -t abc12 > out.txt
+  clauded detect test out.txt --codecmd opencode --rules ./detect.yaml`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return src.DetectTest(args[0], codeCmd, rulesFiles)
+		},
+	}
+	testCmd.Flags().StringVar(&codeCmd, "codecmd", "claude", "Rule profile to use (claude, opencode, kimi, gemini)")
+	testCmd.Flags().StringArrayVar(&rulesFiles, "rules", []string{}, "Extra rules file, applied after the global and project ones")
+	detectCmd.AddCommand(testCmd)
+
+	return detectCmd
+}
+
+func runServe(session, password, authName, codeCmd, remote, flags, token string, envVars, attachPorts []string, publicPorts, tcpPorts []int, autoExit, watchPorts, autoAttach bool, idleTimeout time.Duration, permissionPrompts, insecureSkipVerify, skipInstall, daemon bool) error {
 	// Check and install claude-code if needed (only for claude command)
 	if !skipInstall && codeCmd == "claude" {
 		installer := src.NewInstaller()
@@ -123,6 +299,11 @@ func runServe(session, password, authName, codeCmd, remote, flags string, envVar
 		remote = "https://clauded.friddle.me"
 	}
 
+	ports, portAliases, err := src.ParseAttachPorts(attachPorts)
+	if err != nil {
+		return err
+	}
+
 	// Create configuration
 	config := &src.Config{
 		Remote:             remote,
@@ -132,9 +313,17 @@ func runServe(session, password, authName, codeCmd, remote, flags string, envVar
 		CodeCmd:            codeCmd,
 		Flags:              flags,
 		EnvVars:            envVars,
-		AttachPorts:        attachPorts,
+		AttachPorts:        ports,
+		PortAliases:        portAliases,
+		PublicPorts:        publicPorts,
+		TCPPorts:           tcpPorts,
 		AutoExit:           autoExit,
+		WatchPorts:         watchPorts,
+		AutoAttach:         autoAttach,
+		IdleTimeout:        idleTimeout,
+		PermissionPrompts:  permissionPrompts,
 		InsecureSkipVerify: insecureSkipVerify,
+		PikoToken:          token,
 		Daemon:             daemon,
 		SkipInstall:        skipInstall,
 	}
@@ -6,8 +6,10 @@ import (
 	"math/big"
 	"net"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"clauded-client/src/platform"
@@ -24,8 +26,16 @@ type Config struct {
 	EnvVars            []string `json:"-"`                  // environment variables (hidden from JSON, may contain secrets)
 	GottyPort          int      `json:"port"`               // local gotty port (auto allocated)
 	AttachPorts        []int    `json:"attach_ports"`       // additional local ports to forward
+	PortAliases        map[int]string `json:"port_aliases"` // attached port -> alias, served at /<session>/_p/<alias>/
+	PublicPorts        []int    `json:"public_ports"`       // attached ports served without authentication
+	TCPPorts           []int    `json:"tcp_ports"`          // local ports forwarded as raw TCP (databases, SSH, ...)
 	AutoExit           bool     `json:"auto_exit"`          // enable 24-hour auto exit (default: true)
+	WatchPorts         bool     `json:"watch_ports"`        // announce ports opened by the agent
+	AutoAttach         bool     `json:"auto_attach"`        // attach ports opened by the agent
+	IdleTimeout        time.Duration `json:"idle_timeout"`   // quiet period before waiting_for_input, 0 disables
+	PermissionPrompts  bool     `json:"permission_prompts"` // publish tool approval prompts and answer them from notifications
 	InsecureSkipVerify bool     `json:"insecure_skip_verify"` // skip HTTPS certificate verification
+	PikoToken          string   `json:"-"`                  // piko upstream token (hidden from JSON)
 	Daemon             bool     `json:"daemon"`             // run as daemon (background mode)
 	SkipInstall        bool     `json:"skip_install"`       // skip claude-code installation check
 }
@@ -42,7 +52,11 @@ func NewConfig() *Config {
 		EnvVars:            []string{},
 		GottyPort:          0,                                              // will be auto allocated on startup
 		AutoExit:           getEnvBoolOrDefault("AUTO_EXIT", true),         // read auto exit setting from env, default true
+		WatchPorts:         getEnvBoolOrDefault("WATCH_PORTS", true),       // announce ports opened by the agent, default true
+		IdleTimeout:        30 * time.Second,                               // waiting_for_input after 30s without output
+		PermissionPrompts:  getEnvBoolOrDefault("PERMISSION_PROMPTS", true), // watch for tool approval prompts, default true
 		InsecureSkipVerify: getEnvBoolOrDefault("INSECURE_SKIP_VERIFY", false), // read skip cert verify from env, default false
+		PikoToken:          getEnvOrDefault("PIKO_TOKEN", ""),
 		Daemon:             getEnvBoolOrDefault("DAEMON", true),            // read daemon mode from env, default true
 		SkipInstall:        false,
 	}
@@ -70,6 +84,40 @@ func (c *Config) Validate() error {
 	// Security mechanism for default host
 	isDefaultHost := platform.IsDefaultHost(hostname)
 
+	if c.IdleTimeout < 0 {
+		return fmt.Errorf("idle timeout cannot be negative")
+	}
+
+	for _, port := range c.PublicPorts {
+		if !c.IsAttachedPort(port) {
+			return fmt.Errorf("public port %d is not an attached port", port)
+		}
+	}
+
+	for _, port := range c.TCPPorts {
+		if port <= 0 || port > 65535 {
+			return fmt.Errorf("invalid TCP port %d", port)
+		}
+		if c.IsAttachedPort(port) {
+			return fmt.Errorf("port %d cannot be attached as both HTTP and TCP", port)
+		}
+	}
+
+	if c.Session != "" && !sessionIDPattern.MatchString(c.Session) {
+		return fmt.Errorf("invalid session %q: use lowercase letters, digits and underscores", c.Session)
+	}
+
+	seen := make(map[string]int)
+	for port, alias := range c.PortAliases {
+		if !portAliasPattern.MatchString(alias) {
+			return fmt.Errorf("invalid alias %q for port %d: use letters, digits and underscores", alias, port)
+		}
+		if other, ok := seen[alias]; ok {
+			return fmt.Errorf("alias %q is used by ports %d and %d", alias, other, port)
+		}
+		seen[alias] = port
+	}
+
 	if isDefaultHost {
 		// For default host, auto-generate both session and password if not provided
 		if c.Session == "" {
@@ -91,6 +139,89 @@ func (c *Config) Validate() error {
 	return nil
 }
 
+// IsAttachedPort checks if a port is forwarded with --attach-ports
+func (c *Config) IsAttachedPort(port int) bool {
+	for _, p := range c.AttachPorts {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
+
+// sessionIDPattern keeps "-" out of session IDs, it separates the session from
+// the port in piko endpoint IDs. Only lowercase letters, as subdomains are case-insensitive.
+var sessionIDPattern = regexp.MustCompile(`^[a-z0-9_]{1,64}$`)
+
+// portAliasPattern restricts aliases to characters that fit in a piko endpoint ID
+var portAliasPattern = regexp.MustCompile(`^[A-Za-z0-9_]{1,32}$`)
+
+// ParseAttachPorts parses --attach-ports values, "3000" or "name=3000".
+// A port may be given once and an alias used by one port only.
+func ParseAttachPorts(specs []string) ([]int, map[int]string, error) {
+	var ports []int
+	aliases := make(map[int]string)
+	seen := make(map[int]bool)
+	aliasPorts := make(map[string]int)
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		alias, portStr, named := strings.Cut(spec, "=")
+		if !named {
+			portStr = alias
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil || port <= 0 || port > 65535 {
+			return nil, nil, fmt.Errorf("invalid attach port %q", spec)
+		}
+		if seen[port] {
+			return nil, nil, fmt.Errorf("port %d is attached more than once", port)
+		}
+		if named {
+			if !portAliasPattern.MatchString(alias) {
+				return nil, nil, fmt.Errorf("invalid alias in attach port %q: use letters, digits and underscores", spec)
+			}
+			if other, used := aliasPorts[alias]; used {
+				return nil, nil, fmt.Errorf("alias %q is used by port %d", alias, other)
+			}
+			aliasPorts[alias] = port
+			aliases[port] = alias
+		}
+		seen[port] = true
+		ports = append(ports, port)
+	}
+	return ports, aliases, nil
+}
+
+// PortEndpointID returns the piko endpoint ID of an attached port:
+// {sessionID}-{port}, or {sessionID}-{port}_{alias} for a named port
+func (c *Config) PortEndpointID(port int) string {
+	if alias, ok := c.PortAliases[port]; ok {
+		return fmt.Sprintf("%s-%d_%s", c.GetSessionID(), port, alias)
+	}
+	return fmt.Sprintf("%s-%d", c.GetSessionID(), port)
+}
+
+// PortPath returns the path of an attached port on the server
+func (c *Config) PortPath(port int) string {
+	if alias, ok := c.PortAliases[port]; ok {
+		return fmt.Sprintf("/%s/_p/%s/", c.GetSessionID(), alias)
+	}
+	return fmt.Sprintf("/%s/%d/", c.GetSessionID(), port)
+}
+
+// IsPublicPort checks if an attached port is served without authentication
+func (c *Config) IsPublicPort(port int) bool {
+	for _, p := range c.PublicPorts {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
+
 // GetSessionID returns the session ID (generates one if needed)
 func (c *Config) GetSessionID() string {
 	if c.Session == "" {
@@ -206,7 +337,7 @@ func isPortAvailable(port int) bool {
 
 // generateSessionID generates a unique session ID
 func generateSessionID() string {
-	return uuid.New().String()
+	return strings.ReplaceAll(uuid.New().String(), "-", "")
 }
 
 // generateShortSessionID generates a short session ID
@@ -300,12 +431,38 @@ func (c *Config) ToArgs() []string {
 
 	// --attach-ports (multiple)
 	for _, port := range c.AttachPorts {
-		args = append(args, "--attach-ports", fmt.Sprintf("%d", port))
+		if alias, ok := c.PortAliases[port]; ok {
+			args = append(args, "--attach-ports", fmt.Sprintf("%s=%d", alias, port))
+		} else {
+			args = append(args, "--attach-ports", fmt.Sprintf("%d", port))
+		}
+	}
+
+	// --attach-tcp (multiple)
+	for _, port := range c.TCPPorts {
+		args = append(args, "--attach-tcp", fmt.Sprintf("%d", port))
+	}
+
+	// --public-ports (multiple)
+	for _, port := range c.PublicPorts {
+		args = append(args, "--public-ports", fmt.Sprintf("%d", port))
 	}
 
 	// --auto-exit
 	args = append(args, fmt.Sprintf("--auto-exit=%t", c.AutoExit))
 
+	// --watch-ports, --auto-attach
+	args = append(args, fmt.Sprintf("--watch-ports=%t", c.WatchPorts))
+	if c.AutoAttach {
+		args = append(args, "--auto-attach")
+	}
+
+	// --idle-timeout
+	args = append(args, fmt.Sprintf("--idle-timeout=%s", c.IdleTimeout))
+
+	// --permission-prompts
+	args = append(args, fmt.Sprintf("--permission-prompts=%t", c.PermissionPrompts))
+
 	// --insecure-skip-verify
 	if c.InsecureSkipVerify {
 		args = append(args, "--insecure-skip-verify")
@@ -0,0 +1,149 @@
+package src
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/andydunstall/piko/pkg/websocket"
+)
+
+// ConnectOptions configures a local tunnel to a raw TCP port of a session
+type ConnectOptions struct {
+	Remote             string // server address, same as --remote
+	Session            string // session ID
+	Port               int    // port attached with --attach-tcp on the session's machine
+	LocalAddr          string // local listen address, e.g. 127.0.0.1:15432
+	AuthName           string // terminal username
+	Password           string // terminal password, exchanged for the session token
+	Token              string // session token, skips the password exchange
+	InsecureSkipVerify bool
+}
+
+// Connect listens on LocalAddr and tunnels every accepted connection to the
+// session's TCP port through the server until ctx is cancelled
+func Connect(ctx context.Context, opts ConnectOptions) error {
+	cfg := &Config{Remote: opts.Remote}
+	serverURL := strings.TrimRight(cfg.GetHTTPURL(), "/")
+
+	var tlsConfig *tls.Config
+	if opts.InsecureSkipVerify {
+		tlsConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	token := opts.Token
+	if token == "" {
+		var err error
+		token, err = fetchSessionToken(serverURL, opts, tlsConfig)
+		if err != nil {
+			return err
+		}
+	}
+
+	dialURL, err := tcpDialURL(serverURL, fmt.Sprintf("%s-%d", opts.Session, opts.Port))
+	if err != nil {
+		return err
+	}
+
+	ln, err := net.Listen("tcp", opts.LocalAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", opts.LocalAddr, err)
+	}
+	defer ln.Close()
+
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	fmt.Printf("🔌 Forwarding %s -> session %s port %d\n", ln.Addr(), opts.Session, opts.Port)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("accept failed: %w", err)
+		}
+		go forwardTCP(ctx, conn, dialURL, token, tlsConfig)
+	}
+}
+
+// forwardTCP copies a local connection to and from a WebSocket tunnel
+func forwardTCP(ctx context.Context, local net.Conn, dialURL, token string, tlsConfig *tls.Config) {
+	defer local.Close()
+
+	dialCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	remote, err := websocket.Dial(dialCtx, dialURL, websocket.WithToken(token), websocket.WithTLSConfig(tlsConfig))
+	if err != nil {
+		log.Printf("❌ Failed to open tunnel: %v", err)
+		return
+	}
+	defer remote.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(remote, local)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(local, remote)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// fetchSessionToken exchanges the terminal credential for the session token
+func fetchSessionToken(serverURL string, opts ConnectOptions, tlsConfig *tls.Config) (string, error) {
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/api/v1/sessions/%s/token", serverURL, opts.Session), nil)
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(opts.AuthName, opts.Password)
+
+	httpClient := &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to reach server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return "", fmt.Errorf("authentication failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var result RegisterResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode token response: %w", err)
+	}
+	return result.Token, nil
+}
+
+// tcpDialURL returns the WebSocket URL of piko's TCP route on the server
+func tcpDialURL(serverURL, endpointID string) (string, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid remote %q: %w", serverURL, err)
+	}
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	default:
+		u.Scheme = "ws"
+	}
+	u.Path = strings.TrimRight(u.Path, "/") + "/_piko/v1/tcp/" + endpointID
+	return u.String(), nil
+}
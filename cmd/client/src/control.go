@@ -0,0 +1,333 @@
+package src
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ControlRequest is sent to a running session over its control socket
+type ControlRequest struct {
+	Action string     `json:"action"` // "attach", "detach" or "hook"
+	Port   int        `json:"port"`
+	Alias  string     `json:"alias,omitempty"`
+	TCP    bool       `json:"tcp,omitempty"`
+	Public bool       `json:"public,omitempty"`
+	Hook   *HookInput `json:"hook,omitempty"`
+}
+
+// ControlResponse is the session's answer to a ControlRequest
+type ControlResponse struct {
+	Message string `json:"message,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// controlSocketPath returns the control socket of a session, next to its session file
+func controlSocketPath(sessionID string) (string, error) {
+	dir, err := getSessionDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, sessionID+".sock"), nil
+}
+
+// listenControl opens the control socket of a session, replacing a stale one
+func listenControl(sessionID string) (net.Listener, error) {
+	path, err := controlSocketPath(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(path)
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+	// Only the session owner may attach ports
+	if err := os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// serveControl answers control requests until the listener is closed,
+// each connection in its own goroutine so a slow request (an attach waiting
+// on the server, a hook) does not hold up the others
+func (sm *ServiceManager) serveControl(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if sm.ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go sm.handleControl(conn)
+	}
+}
+
+func (sm *ServiceManager) handleControl(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Minute))
+
+	var req ControlRequest
+	var resp ControlResponse
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&req); err != nil {
+		resp.Error = fmt.Sprintf("invalid request: %v", err)
+	} else {
+		var err error
+		switch req.Action {
+		case "attach":
+			err = sm.attachPort(req)
+		case "detach":
+			err = sm.detachPort(req.Port)
+		case "hook":
+			err = sm.handleHook(req.Hook)
+		default:
+			err = fmt.Errorf("unknown action %q", req.Action)
+		}
+		if err != nil {
+			resp.Error = err.Error()
+		} else if req.Action != "hook" {
+			resp.Message = sm.describePort(req)
+		}
+	}
+
+	json.NewEncoder(conn).Encode(resp)
+}
+
+// attachPort starts forwarding a port of a running session
+func (sm *ServiceManager) attachPort(req ControlRequest) error {
+	if req.Port <= 0 || req.Port > 65535 {
+		return fmt.Errorf("invalid port %d", req.Port)
+	}
+	if req.TCP && (req.Public || req.Alias != "") {
+		return fmt.Errorf("TCP ports cannot be public or have an alias")
+	}
+
+	sm.attachMu.Lock()
+	defer sm.attachMu.Unlock()
+
+	sm.mu.Lock()
+	if sm.config.IsAttachedPort(req.Port) || containsPort(sm.config.TCPPorts, req.Port) {
+		sm.mu.Unlock()
+		return fmt.Errorf("port %d is already attached", req.Port)
+	}
+	if req.Alias != "" {
+		if !portAliasPattern.MatchString(req.Alias) {
+			sm.mu.Unlock()
+			return fmt.Errorf("invalid alias %q: use letters, digits and underscores", req.Alias)
+		}
+		for port, alias := range sm.config.PortAliases {
+			if alias == req.Alias {
+				sm.mu.Unlock()
+				return fmt.Errorf("alias %q is used by port %d", req.Alias, port)
+			}
+		}
+		if sm.config.PortAliases == nil {
+			sm.config.PortAliases = make(map[int]string)
+		}
+		sm.config.PortAliases[req.Port] = req.Alias
+	}
+	sm.mu.Unlock()
+
+	if err := sm.startPortListener(req.Port, req.TCP); err != nil {
+		sm.mu.Lock()
+		delete(sm.config.PortAliases, req.Port)
+		sm.mu.Unlock()
+		return err
+	}
+
+	sm.mu.Lock()
+	if req.TCP {
+		sm.config.TCPPorts = append(sm.config.TCPPorts, req.Port)
+	} else {
+		sm.config.AttachPorts = append(sm.config.AttachPorts, req.Port)
+	}
+	if req.Public {
+		sm.config.PublicPorts = append(sm.config.PublicPorts, req.Port)
+	}
+	sm.mu.Unlock()
+
+	log.Printf("📌 Port %d attached", req.Port)
+	sm.portsChanged()
+	return nil
+}
+
+// detachPort stops forwarding a port of a running session
+func (sm *ServiceManager) detachPort(port int) error {
+	sm.attachMu.Lock()
+	defer sm.attachMu.Unlock()
+
+	sm.mu.Lock()
+	cancel, ok := sm.listeners[port]
+	if !ok {
+		sm.mu.Unlock()
+		return fmt.Errorf("port %d is not attached", port)
+	}
+	cancel()
+	delete(sm.listeners, port)
+	endpointID := sm.config.PortEndpointID(port)
+	delete(sm.endpoints, endpointID)
+	sm.config.AttachPorts = removePort(sm.config.AttachPorts, port)
+	sm.config.TCPPorts = removePort(sm.config.TCPPorts, port)
+	sm.config.PublicPorts = removePort(sm.config.PublicPorts, port)
+	delete(sm.config.PortAliases, port)
+	sm.mu.Unlock()
+
+	// The detached endpoint may have been the only one down
+	sm.updateConnection(endpointID, nil)
+
+	log.Printf("📌 Port %d detached", port)
+	sm.portsChanged()
+	return nil
+}
+
+// describePort tells the user where an attached port can be reached
+func (sm *ServiceManager) describePort(req ControlRequest) string {
+	if req.Action == "detach" {
+		return fmt.Sprintf("Port %d detached", req.Port)
+	}
+	if req.TCP {
+		return fmt.Sprintf("Port %d attached as TCP -> clauded connect %s %d --remote %s", req.Port, sm.config.GetSessionID(), req.Port, sm.config.Remote)
+	}
+
+	sm.mu.Lock()
+	path := sm.config.PortPath(req.Port)
+	sm.mu.Unlock()
+	return fmt.Sprintf("Port %d attached -> %s%s", req.Port, sm.config.GetHTTPURL(), path)
+}
+
+// portsChanged saves the session file and tells the server about public and TCP ports
+func (sm *ServiceManager) portsChanged() {
+	sm.mu.Lock()
+	if sm.info != nil {
+		if err := saveSessionInfo(sm.info); err != nil {
+			log.Printf("Failed to save session info: %v", err)
+		}
+	}
+	sm.mu.Unlock()
+
+	go func() {
+		if err := sm.register(); err != nil {
+			log.Printf("Session registration failed: %v", err)
+		}
+	}()
+}
+
+// register reports the session and its current ports to the server registry
+func (sm *ServiceManager) register() error {
+	sm.mu.Lock()
+	codeCmd := sm.config.CodeCmd
+	publicPorts := append([]int(nil), sm.config.PublicPorts...)
+	tcpPorts := append([]int(nil), sm.config.TCPPorts...)
+	sm.mu.Unlock()
+
+	if err := sm.notifier.Register(codeCmd, publicPorts, tcpPorts); err != nil {
+		return err
+	}
+
+	// Save the token for clauded notify, it changes when the server restarts
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if sm.info != nil && sm.info.Token != sm.notifier.Token() {
+		sm.info.Token = sm.notifier.Token()
+		if err := saveSessionInfo(sm.info); err != nil {
+			log.Printf("Failed to save session info: %v", err)
+		}
+	}
+	return nil
+}
+
+// AttachPort asks a running session to forward another port.
+// spec is "3000" or "name=3000" like --attach-ports.
+func AttachPort(sessionID, spec string, tcp, public bool) error {
+	ports, aliases, err := ParseAttachPorts([]string{spec})
+	if err != nil {
+		return err
+	}
+	if len(ports) != 1 {
+		return fmt.Errorf("invalid port %q", spec)
+	}
+
+	return sendControl(sessionID, ControlRequest{
+		Action: "attach",
+		Port:   ports[0],
+		Alias:  aliases[ports[0]],
+		TCP:    tcp,
+		Public: public,
+	})
+}
+
+// DetachPort asks a running session to stop forwarding a port
+func DetachPort(sessionID string, port int) error {
+	return sendControl(sessionID, ControlRequest{
+		Action: "detach",
+		Port:   port,
+	})
+}
+
+// sendControl sends a request to a running session and prints its answer
+func sendControl(sessionID string, req ControlRequest) error {
+	message, err := requestControl(sessionID, req)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("✅ %s\n", message)
+	return nil
+}
+
+// requestControl sends a request to a running session and returns its answer
+func requestControl(sessionID string, req ControlRequest) (string, error) {
+	path, err := controlSocketPath(sessionID)
+	if err != nil {
+		return "", err
+	}
+
+	conn, err := net.DialTimeout("unix", path, 5*time.Second)
+	if err != nil {
+		return "", fmt.Errorf("session %s is not running or has no control socket: %w", sessionID, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Minute))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return "", fmt.Errorf("failed to send request: %w", err)
+	}
+
+	var resp ControlResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.Error != "" {
+		return "", errors.New(resp.Error)
+	}
+	return resp.Message, nil
+}
+
+func containsPort(ports []int, port int) bool {
+	for _, p := range ports {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
+
+func removePort(ports []int, port int) []int {
+	out := ports[:0]
+	for _, p := range ports {
+		if p != port {
+			out = append(out, p)
+		}
+	}
+	return out
+}
@@ -0,0 +1,284 @@
+package src
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Rule kinds
+const (
+	RuleCompletion = "completion"
+	RuleError      = "error"
+	RuleIgnore     = "ignore" // lines matching an ignore rule never trigger a notification
+)
+
+// detectRulesFiles are the names a rules file is looked up under, JSON is read as YAML
+var detectRulesFiles = []string{"detect.yaml", "detect.yml", "detect.json"}
+
+// DetectionRule matches output lines, by literal substring (Match) or regular expression (Regex)
+type DetectionRule struct {
+	Name   string   `yaml:"name,omitempty"`
+	Kind   string   `yaml:"kind"` // completion, error or ignore
+	Match  string   `yaml:"match,omitempty"`
+	Regex  string   `yaml:"regex,omitempty"`
+	Unless []string `yaml:"unless,omitempty"` // regexes that stop the rule from matching a line
+}
+
+// DetectionRules is the content of a rules file
+//
+//	defaults: false        # drop the built-in rules
+//	rules:                 # rules for every codecmd
+//	  - {kind: completion, regex: '^ok\s+\S+'}
+//	profiles:              # extra rules per codecmd (claude, opencode, kimi, gemini, ...)
+//	  opencode:
+//	    - {kind: error, match: "ProviderError", unless: ['retrying']}
+type DetectionRules struct {
+	Defaults *bool                      `yaml:"defaults,omitempty"`
+	Rules    []DetectionRule            `yaml:"rules,omitempty"`
+	Profiles map[string][]DetectionRule `yaml:"profiles,omitempty"`
+}
+
+// defaultDetectionRules are the built-in rules, completion markers only count
+// on lines that don't also look like an error or a warning
+func defaultDetectionRules() []DetectionRule {
+	notErrorOrWarning := []string{`error:|ERROR:|Error:|failed|Failed|exception`, `warning:|WARNING:|Warning:|warn:`}
+
+	var rules []DetectionRule
+	for _, pattern := range []string{
+		"✓", "✅", "Done", "Completed", "Finished", "Success", "Build successful",
+		"Tests passed", "All tests passed", "Installation complete", "Deployment complete",
+	} {
+		rules = append(rules, DetectionRule{Name: "builtin:" + pattern, Kind: RuleCompletion, Match: pattern, Unless: notErrorOrWarning})
+	}
+	for _, pattern := range []string{
+		"Error:", "ERROR", "Failed", "Exception", "fatal:", "Fatal error", "panic:",
+		"Cannot find module", "Compilation failed",
+	} {
+		rules = append(rules, DetectionRule{Name: "builtin:" + pattern, Kind: RuleError, Match: pattern})
+	}
+	return rules
+}
+
+// DetectionRulesPaths returns the rules files in use, global (~/.clauded) first, then
+// the project's (.clauded in the working directory), as absolute paths
+func DetectionRulesPaths() []string {
+	var dirs []string
+	global := ""
+	if home, err := os.UserHomeDir(); err == nil {
+		global = filepath.Join(home, ".clauded")
+		dirs = append(dirs, global)
+	}
+	if project := ProjectRulesDir(); project != "" && project != global {
+		dirs = append(dirs, project)
+	}
+
+	var paths []string
+	for _, dir := range dirs {
+		for _, name := range detectRulesFiles {
+			path := filepath.Join(dir, name)
+			if _, err := os.Stat(path); err == nil {
+				paths = append(paths, path)
+				break // Only the first found file per directory
+			}
+		}
+	}
+	return paths
+}
+
+// ProjectRulesDir returns the absolute path of the working directory's .clauded,
+// whose rules files are picked up without being asked for
+func ProjectRulesDir() string {
+	dir, err := filepath.Abs(".clauded")
+	if err != nil {
+		return ""
+	}
+	return dir
+}
+
+// LoadDetectionRules returns the rules for a codecmd from the given files,
+// applied in order after the built-in rules
+func LoadDetectionRules(codeCmd string, paths []string) ([]DetectionRule, error) {
+	useDefaults := true
+	var rules []DetectionRule
+	for _, path := range paths {
+		file, err := readDetectionRules(path)
+		if err != nil {
+			return nil, err
+		}
+		if file.Defaults != nil {
+			useDefaults = *file.Defaults
+		}
+		rules = append(rules, file.Rules...)
+		rules = append(rules, file.Profiles[codeCmd]...)
+	}
+
+	if useDefaults {
+		rules = append(defaultDetectionRules(), rules...)
+	}
+	return rules, nil
+}
+
+// readDetectionRules parses a rules file, rejecting unknown fields so typos don't go unnoticed
+func readDetectionRules(path string) (*DetectionRules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file DetectionRules
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	// An empty file is no error
+	if err := decoder.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("invalid rules file %s: %w", path, err)
+	}
+
+	for i, rule := range file.Rules {
+		if _, err := compileRule(rule); err != nil {
+			return nil, fmt.Errorf("invalid rules file %s: rules[%d]: %w", path, i, err)
+		}
+	}
+	for profile, rules := range file.Profiles {
+		for i, rule := range rules {
+			if _, err := compileRule(rule); err != nil {
+				return nil, fmt.Errorf("invalid rules file %s: profiles.%s[%d]: %w", path, profile, i, err)
+			}
+		}
+	}
+	return &file, nil
+}
+
+// compiledRule is a DetectionRule ready for matching
+type compiledRule struct {
+	name    string
+	kind    string
+	literal string
+	re      *regexp.Regexp
+	unless  []*regexp.Regexp
+}
+
+// compileRule validates a rule and compiles its regular expressions
+func compileRule(rule DetectionRule) (*compiledRule, error) {
+	switch rule.Kind {
+	case RuleCompletion, RuleError, RuleIgnore:
+	default:
+		return nil, fmt.Errorf("kind must be completion, error or ignore, got %q", rule.Kind)
+	}
+	if (rule.Match == "") == (rule.Regex == "") {
+		return nil, fmt.Errorf("exactly one of match and regex is required")
+	}
+
+	cr := &compiledRule{
+		name:    rule.Name,
+		kind:    rule.Kind,
+		literal: rule.Match,
+	}
+	if cr.name == "" {
+		cr.name = rule.Match + rule.Regex
+	}
+	if rule.Regex != "" {
+		re, err := regexp.Compile(rule.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("regex: %w", err)
+		}
+		cr.re = re
+	}
+	for _, pattern := range rule.Unless {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("unless: %w", err)
+		}
+		cr.unless = append(cr.unless, re)
+	}
+	return cr, nil
+}
+
+// matches reports whether the rule fires for a line
+func (cr *compiledRule) matches(line string) bool {
+	if cr.re != nil {
+		if !cr.re.MatchString(line) {
+			return false
+		}
+	} else if !strings.Contains(line, cr.literal) {
+		return false
+	}
+
+	for _, re := range cr.unless {
+		if re.MatchString(line) {
+			return false
+		}
+	}
+	return true
+}
+
+// LoadTaskDetector creates a task detector for a codecmd from the rules files
+// in use plus any extra files, and returns the files it read
+func LoadTaskDetector(notifier *Notifier, codeCmd string, extraPaths ...string) (*TaskDetector, []string, error) {
+	paths := append(DetectionRulesPaths(), extraPaths...)
+	rules, err := LoadDetectionRules(codeCmd, paths)
+	if err != nil {
+		return nil, paths, err
+	}
+	td, err := NewTaskDetectorWithRules(notifier, rules)
+	return td, paths, err
+}
+
+// DetectTest dry-runs the detection rules of a codecmd against captured output
+// ("-" reads stdin) and prints the lines that would trigger notifications
+func DetectTest(path, codeCmd string, extraPaths []string) error {
+	td, paths, err := LoadTaskDetector(nil, codeCmd, extraPaths...)
+	if err != nil {
+		return err
+	}
+	if len(paths) > 0 {
+		fmt.Printf("Rules files: %s\n", strings.Join(paths, ", "))
+	} else {
+		fmt.Printf("Rules files: (none, built-in rules only)\n")
+	}
+	fmt.Printf("Profile: %s\n\n", codeCmd)
+
+	input := os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		input = f
+	}
+
+	counts := make(map[string]int)
+	lineNo := 0
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		lineNo++
+		// The tmux watcher matches trimmed lines too
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		for _, kind := range []string{RuleIgnore, RuleCompletion, RuleError} {
+			if name, ok := td.MatchRule(line, kind); ok {
+				fmt.Printf("%5d  %-10s  %-28s  %s\n", lineNo, kind, name, line)
+				counts[kind]++
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	fmt.Printf("\n%d lines: %d completion, %d error, %d ignored\n",
+		lineNo, counts[RuleCompletion], counts[RuleError], counts[RuleIgnore])
+	return nil
+}
@@ -4,49 +4,36 @@ import (
 	"bufio"
 	"io"
 	"log"
-	"regexp"
 	"strings"
 	"sync"
 )
 
 // TaskDetector detects task completion from output
 type TaskDetector struct {
-	notifier      *Notifier
-	completionPatterns []string
-	errorPatterns     []string
-	lastOutput        string
-	mu                sync.Mutex
+	notifier   *Notifier
+	rules      []*compiledRule
+	lastOutput string
+	mu         sync.Mutex
 }
 
-// NewTaskDetector creates a new task detector
+// NewTaskDetector creates a new task detector with the built-in rules
 func NewTaskDetector(notifier *Notifier) *TaskDetector {
-	return &TaskDetector{
-		notifier: notifier,
-		completionPatterns: []string{
-			"✓",                    // Checkmark
-			"✅",                   // Green checkmark
-			"Done",                 // Done
-			"Completed",            // Completed
-			"Finished",             // Finished
-			"Success",              // Success
-			"Build successful",     // Build successful
-			"Tests passed",         // Tests passed
-			"All tests passed",     // All tests passed
-			"Installation complete", // Installation complete
-			"Deployment complete",   // Deployment complete
-		},
-		errorPatterns: []string{
-			"Error:",              // Error:
-			"ERROR",               // ERROR
-			"Failed",              // Failed
-			"Exception",           // Exception
-			"fatal:",              // fatal:
-			"Fatal error",         // Fatal error
-			"panic:",              // panic:
-			"Cannot find module",  // Node.js error
-			"Compilation failed",  // Compilation error
-		},
+	td, err := NewTaskDetectorWithRules(notifier, defaultDetectionRules())
+	if err != nil {
+		panic(err) // the built-in rules always compile
+	}
+	return td
+}
+
+// NewTaskDetectorWithRules creates a task detector matching the given rules, see LoadDetectionRules
+func NewTaskDetectorWithRules(notifier *Notifier, rules []DetectionRule) (*TaskDetector, error) {
+	td := &TaskDetector{notifier: notifier}
+	for _, rule := range rules {
+		if err := td.AddRule(rule); err != nil {
+			return nil, err
+		}
 	}
+	return td, nil
 }
 
 // DetectFromReader reads output and detects task completion
@@ -96,54 +83,38 @@ func (td *TaskDetector) DetectFromReader(reader io.Reader) {
 	}
 }
 
-// detectCompletion checks if the line contains a completion pattern
-func (td *TaskDetector) detectCompletion(line string) bool {
-	for _, pattern := range td.completionPatterns {
-		if strings.Contains(line, pattern) {
-			// Additional checks to avoid false positives
-			// Skip if it's part of an error message or warning
-			if !td.isErrorLine(line) && !td.isWarningLine(line) {
-				return true
-			}
+// MatchRule returns the name of the rule of a kind that fires for a line, the
+// last added one if several do. A line matching an ignore rule matches no other kind.
+func (td *TaskDetector) MatchRule(line, kind string) (string, bool) {
+	if kind != RuleIgnore {
+		if _, ignored := td.MatchRule(line, RuleIgnore); ignored {
+			return "", false
 		}
 	}
-	return false
-}
+	// Rules are only appended, a snapshot stays valid while matching
+	td.mu.Lock()
+	rules := td.rules
+	td.mu.Unlock()
 
-// detectError checks if the line contains an error pattern
-func (td *TaskDetector) detectError(line string) bool {
-	for _, pattern := range td.errorPatterns {
-		if strings.Contains(line, pattern) {
-			return true
+	// Later rules are more specific: configured ones come after the built-in ones
+	for i := len(rules) - 1; i >= 0; i-- {
+		if rule := rules[i]; rule.kind == kind && rule.matches(line) {
+			return rule.name, true
 		}
 	}
-	return false
+	return "", false
 }
 
-// isErrorLine checks if the line is an error message
-func (td *TaskDetector) isErrorLine(line string) bool {
-	errorIndicators := []string{
-		"error:", "ERROR:", "Error:", "failed", "Failed", "exception",
-	}
-	for _, indicator := range errorIndicators {
-		if strings.Contains(line, indicator) {
-			return true
-		}
-	}
-	return false
+// detectCompletion checks if the line matches a completion rule
+func (td *TaskDetector) detectCompletion(line string) bool {
+	_, ok := td.MatchRule(line, RuleCompletion)
+	return ok
 }
 
-// isWarningLine checks if the line is a warning message
-func (td *TaskDetector) isWarningLine(line string) bool {
-	warningIndicators := []string{
-		"warning:", "WARNING:", "Warning:", "warn:",
-	}
-	for _, indicator := range warningIndicators {
-		if strings.Contains(line, indicator) {
-			return true
-		}
-	}
-	return false
+// detectError checks if the line matches an error rule
+func (td *TaskDetector) detectError(line string) bool {
+	_, ok := td.MatchRule(line, RuleError)
+	return ok
 }
 
 // DetectCompletionFromString detects completion from a string
@@ -159,28 +130,32 @@ func (td *TaskDetector) DetectCompletionFromString(output string) bool {
 
 // AddCompletionPattern adds a custom completion pattern
 func (td *TaskDetector) AddCompletionPattern(pattern string) {
-	td.completionPatterns = append(td.completionPatterns, pattern)
+	td.AddRule(DetectionRule{Kind: RuleCompletion, Match: pattern})
 }
 
 // AddErrorPattern adds a custom error pattern
 func (td *TaskDetector) AddErrorPattern(pattern string) {
-	td.errorPatterns = append(td.errorPatterns, pattern)
+	td.AddRule(DetectionRule{Kind: RuleError, Match: pattern})
 }
 
 // AddRegexPattern adds a regex pattern for detection
 func (td *TaskDetector) AddRegexPattern(pattern string, isCompletion bool) error {
-	re, err := regexp.Compile(pattern)
-	if err != nil {
-		return err
-	}
-
+	kind := RuleError
 	if isCompletion {
-		td.completionPatterns = append(td.completionPatterns, pattern)
-		_ = re // Use regex for matching (implementation can be added later)
-	} else {
-		td.errorPatterns = append(td.errorPatterns, pattern)
+		kind = RuleCompletion
 	}
+	return td.AddRule(DetectionRule{Kind: kind, Regex: pattern})
+}
 
+// AddRule adds a detection rule
+func (td *TaskDetector) AddRule(rule DetectionRule) error {
+	cr, err := compileRule(rule)
+	if err != nil {
+		return err
+	}
+	td.mu.Lock()
+	td.rules = append(td.rules, cr)
+	td.mu.Unlock()
 	return nil
 }
 
@@ -0,0 +1,288 @@
+package src
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// hookEvents are the Claude Code hook events forwarded as notifications.
+// UserPromptSubmit only cancels a pending idle notification.
+var hookEvents = []string{"Stop", "SubagentStop", "Notification", "UserPromptSubmit"}
+
+// hookCommandMarker identifies hook entries installed by clauded when merging
+const hookCommandMarker = " hook --session "
+
+// maxHookOutput caps the assistant message quoted in a completion notification
+const maxHookOutput = 500
+
+// HookInput is the event Claude Code passes to a hook command on stdin
+type HookInput struct {
+	SessionID        string `json:"session_id"` // Claude's conversation ID, not the clauded session
+	TranscriptPath   string `json:"transcript_path"`
+	Cwd              string `json:"cwd"`
+	HookEventName    string `json:"hook_event_name"`
+	Message          string `json:"message,omitempty"`           // Notification only
+	Title            string `json:"title,omitempty"`             // Notification only
+	NotificationType string `json:"notification_type,omitempty"` // Notification only, e.g. permission_prompt
+	StopHookActive   bool   `json:"stop_hook_active,omitempty"`
+}
+
+// hookSettingsPath returns the Claude Code settings file of a session.
+// It lives in a subdirectory so session listing doesn't mistake it for a session file.
+func hookSettingsPath(sessionID string) (string, error) {
+	dir, err := getSessionDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "hooks", sessionID+".json"), nil
+}
+
+// installHooks merges clauded's hook entries into the session's settings file,
+// passed to claude with --settings, and returns its path. Entries added by
+// hand are kept, entries from an earlier run of the session are replaced.
+func installHooks(sessionID string) (string, error) {
+	path, err := hookSettingsPath(sessionID)
+	if err != nil {
+		return "", err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to get executable path: %w", err)
+	}
+
+	settings := make(map[string]interface{})
+	if data, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, &settings); err != nil {
+			return "", fmt.Errorf("invalid settings file %s: %w", path, err)
+		}
+	}
+	hooks, _ := settings["hooks"].(map[string]interface{})
+	if hooks == nil {
+		hooks = make(map[string]interface{})
+	}
+
+	command := shellQuote(exe) + hookCommandMarker + shellQuote(sessionID)
+	for _, event := range hookEvents {
+		groups, _ := hooks[event].([]interface{})
+		merged := make([]interface{}, 0, len(groups)+1)
+		for _, group := range groups {
+			if !isClaudedHookGroup(group) {
+				merged = append(merged, group)
+			}
+		}
+		merged = append(merged, map[string]interface{}{
+			"hooks": []interface{}{
+				map[string]interface{}{
+					"type":    "command",
+					"command": command,
+					"timeout": 10,
+				},
+			},
+		})
+		hooks[event] = merged
+	}
+	settings["hooks"] = hooks
+
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// isClaudedHookGroup reports whether a hook matcher group runs clauded hook
+func isClaudedHookGroup(group interface{}) bool {
+	g, _ := group.(map[string]interface{})
+	entries, _ := g["hooks"].([]interface{})
+	for _, entry := range entries {
+		e, _ := entry.(map[string]interface{})
+		if command, _ := e["command"].(string); strings.Contains(command, hookCommandMarker) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeHooks removes the session's settings file
+func removeHooks(sessionID string) {
+	if path, err := hookSettingsPath(sessionID); err == nil {
+		os.Remove(path)
+	}
+}
+
+// RunHook reads a Claude Code hook event from stdin and hands it to the
+// running session, which publishes it. It prints nothing on stdout, which
+// Claude would read as the hook's decision.
+func RunHook(sessionID string, stdin io.Reader) error {
+	if sessionID == "" {
+		return fmt.Errorf("--session is required")
+	}
+
+	var in HookInput
+	if err := json.NewDecoder(io.LimitReader(stdin, 1<<20)).Decode(&in); err != nil {
+		return fmt.Errorf("invalid hook input: %w", err)
+	}
+
+	_, err := requestControl(sessionID, ControlRequest{
+		Action: "hook",
+		Hook:   &in,
+	})
+	return err
+}
+
+// handleHook publishes a hook event in the background, so the agent isn't kept waiting on the server
+func (sm *ServiceManager) handleHook(in *HookInput) error {
+	if in == nil {
+		return fmt.Errorf("missing hook event")
+	}
+
+	// Any later event means the agent or the user moved on
+	sm.mu.Lock()
+	if sm.idleTimer != nil {
+		sm.idleTimer.Stop()
+		sm.idleTimer = nil
+	}
+	sm.mu.Unlock()
+
+	data := map[string]interface{}{
+		"source":            "hook",
+		"hook_event":        in.HookEventName,
+		"claude_session_id": in.SessionID,
+		"cwd":               in.Cwd,
+		"timestamp":         time.Now().Format(time.RFC3339),
+	}
+
+	var notifType NotificationType
+	idle := false
+	switch in.HookEventName {
+	case "Stop":
+		notifType = TaskCompleted
+		data["task_name"] = "Task Completed"
+		data["output"] = lastAssistantText(in.TranscriptPath)
+	case "SubagentStop":
+		notifType = Progress
+		data["message"] = "Subagent finished"
+	case "Notification":
+		// The permission watcher publishes the prompt as permission_request
+		if sm.permissions && isPermissionNotification(in) {
+			log.Printf("🔔 Hook event: %s (permission prompt, left to the permission watcher)", in.HookEventName)
+			return nil
+		}
+		// Claude's idle notification takes the place of the idle watcher,
+		// held back for --idle-timeout in case the user answers first
+		if sm.config.IdleTimeout > 0 && isIdleNotification(in) {
+			notifType = WaitingForInput
+			data["message"] = in.Message
+			idle = true
+			break
+		}
+		notifType = SystemStatus
+		data["event"] = "notification"
+		data["title"] = in.Title
+		data["message"] = in.Message
+	case "UserPromptSubmit":
+		return nil
+	default:
+		return fmt.Errorf("unsupported hook event %q", in.HookEventName)
+	}
+
+	log.Printf("🔔 Hook event: %s", in.HookEventName)
+	publish := func() {
+		if err := sm.notifier.Publish(notifType, data); err != nil {
+			log.Printf("Failed to send hook notification: %v", err)
+		}
+	}
+	if idle {
+		sm.mu.Lock()
+		sm.idleTimer = time.AfterFunc(sm.config.IdleTimeout, publish)
+		sm.mu.Unlock()
+		return nil
+	}
+	go publish()
+	return nil
+}
+
+// isPermissionNotification reports whether a Notification hook event is a tool
+// approval prompt. Older Claude Code versions only send the message.
+func isPermissionNotification(in *HookInput) bool {
+	if in.NotificationType != "" {
+		return in.NotificationType == "permission_prompt"
+	}
+	return strings.Contains(strings.ToLower(in.Message), "needs your permission")
+}
+
+// isIdleNotification reports whether a Notification hook event says Claude is waiting for input
+func isIdleNotification(in *HookInput) bool {
+	if in.NotificationType != "" {
+		return in.NotificationType == "idle_prompt"
+	}
+	return strings.Contains(strings.ToLower(in.Message), "waiting for your input")
+}
+
+// lastAssistantText returns the text of the last assistant message in a
+// Claude Code transcript (JSON lines), shortened to maxHookOutput
+func lastAssistantText(transcriptPath string) string {
+	if transcriptPath == "" {
+		return ""
+	}
+	f, err := os.Open(transcriptPath)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	// The last message is near the end, skip the start of long transcripts
+	if info, err := f.Stat(); err == nil && info.Size() > 1<<20 {
+		f.Seek(info.Size()-1<<20, io.SeekStart)
+	}
+
+	var text string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var entry struct {
+			Type    string `json:"type"`
+			Message struct {
+				Content []struct {
+					Type string `json:"type"`
+					Text string `json:"text"`
+				} `json:"content"`
+			} `json:"message"`
+		}
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil || entry.Type != "assistant" {
+			continue
+		}
+		var parts []string
+		for _, c := range entry.Message.Content {
+			if c.Type == "text" && strings.TrimSpace(c.Text) != "" {
+				parts = append(parts, strings.TrimSpace(c.Text))
+			}
+		}
+		if len(parts) > 0 {
+			text = strings.Join(parts, "\n")
+		}
+	}
+
+	if runes := []rune(text); len(runes) > maxHookOutput {
+		text = string(runes[:maxHookOutput]) + "…"
+	}
+	return text
+}
+
+// shellQuote quotes a word for the shell Claude Code runs hook commands with
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,209 @@
+package src
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"clauded-client/src/platform"
+)
+
+// inputWaits are the kernel functions a process sleeps in while waiting for
+// terminal input: a blocking read, or select/poll/epoll in event-loop agents
+var inputWaits = map[string]bool{
+	"n_tty_read":            true,
+	"wait_woken":            true,
+	"do_select":             true,
+	"core_sys_select":       true,
+	"do_sys_poll":           true,
+	"poll_schedule_timeout": true,
+	"ep_poll":               true,
+	"do_epoll_wait":         true,
+}
+
+// IdleWatcher publishes waiting_for_input when the agent's tmux pane stops
+// changing for a quiet period after activity. On Linux the wait is confirmed by
+// the pane's foreground processes all sleeping on input, so a build that runs
+// without output doesn't count. Each wait is reported once.
+type IdleWatcher struct {
+	sessionName string
+	codeCmd     string
+	notifier    *Notifier
+	ctx         context.Context
+	timeout     time.Duration
+	interval    time.Duration
+}
+
+// NewIdleWatcher creates an idle watcher for a tmux session
+func NewIdleWatcher(sessionName, codeCmd string, timeout time.Duration, notifier *Notifier, ctx context.Context) *IdleWatcher {
+	return &IdleWatcher{
+		sessionName: sessionName,
+		codeCmd:     codeCmd,
+		notifier:    notifier,
+		ctx:         ctx,
+		timeout:     timeout,
+		interval:    time.Second,
+	}
+}
+
+// Start polls the pane until the context is cancelled
+func (iw *IdleWatcher) Start() error {
+	ticker := time.NewTicker(iw.interval)
+	defer ticker.Stop()
+
+	var (
+		lastContent string
+		lastChange  time.Time
+		captured    bool // lastContent holds a capture
+		active      bool // the pane changed since the last notification
+	)
+
+	for {
+		select {
+		case <-iw.ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		// The tmux session only exists once a browser has connected
+		content, err := iw.capturePane()
+		if err != nil {
+			continue
+		}
+
+		now := time.Now()
+		if !captured || content != lastContent {
+			// The first capture is the starting point, not activity
+			active = active || captured
+			captured = true
+			lastContent = content
+			lastChange = now
+			continue
+		}
+
+		if !active || now.Sub(lastChange) < iw.timeout || !iw.waitingOnInput() {
+			continue
+		}
+		active = false
+
+		idle := now.Sub(lastChange).Round(time.Second)
+		log.Printf("⏸️  %s is waiting for input (quiet for %s)", iw.codeCmd, idle)
+		err = iw.notifier.Publish(WaitingForInput, map[string]interface{}{
+			"message":      fmt.Sprintf("%s is waiting for input", iw.codeCmd),
+			"idle_seconds": int(idle.Seconds()),
+			"last_lines":   lastLines(content, 5),
+			"timestamp":    now.Format(time.RFC3339),
+		})
+		if err != nil {
+			log.Printf("Failed to send waiting_for_input notification: %v", err)
+		}
+	}
+}
+
+// capturePane returns the visible content of the session's pane
+func (iw *IdleWatcher) capturePane() (string, error) {
+	output, err := exec.Command("tmux", "capture-pane", "-t", iw.sessionName, "-p").Output()
+	if err != nil {
+		return "", err
+	}
+	return string(output), nil
+}
+
+// waitingOnInput reports whether every process in the pane's foreground process
+// group sleeps waiting for input. Without /proc it can't tell and reports true.
+func (iw *IdleWatcher) waitingOnInput() bool {
+	if !platform.IsLinux() {
+		return true
+	}
+
+	out, err := exec.Command("tmux", "display-message", "-p", "-t", iw.sessionName, "#{pane_pid}").Output()
+	if err != nil {
+		return true
+	}
+	panePID, err := strconv.Atoi(strings.TrimSpace(string(out)))
+	if err != nil {
+		return true
+	}
+	pane, ok := readProcStat(panePID)
+	if !ok {
+		return true
+	}
+
+	for _, pid := range descendants([]int{panePID}) {
+		stat, ok := readProcStat(pid)
+		if !ok || stat.pgrp != pane.tpgid {
+			continue
+		}
+		if stat.state != "S" {
+			return false
+		}
+		wchan := procWchan(pid)
+		// "0" when the kernel hides wait channels, do_wait is a shell waiting for the agent
+		if wchan == "" || wchan == "0" || wchan == "do_wait" {
+			continue
+		}
+		if !inputWaits[wchan] {
+			return false
+		}
+	}
+	return true
+}
+
+// procStat holds the /proc/<pid>/stat fields the idle watcher needs
+type procStat struct {
+	state string
+	pgrp  int
+	tpgid int // foreground process group of the process's terminal
+}
+
+// readProcStat parses /proc/<pid>/stat
+func readProcStat(pid int) (procStat, bool) {
+	data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return procStat{}, false
+	}
+	// Fields after the parenthesised command: state ppid pgrp session tty_nr tpgid ...
+	idx := strings.LastIndexByte(string(data), ')')
+	if idx < 0 {
+		return procStat{}, false
+	}
+	fields := strings.Fields(string(data[idx+1:]))
+	if len(fields) < 6 {
+		return procStat{}, false
+	}
+	pgrp, err1 := strconv.Atoi(fields[2])
+	tpgid, err2 := strconv.Atoi(fields[5])
+	if err1 != nil || err2 != nil {
+		return procStat{}, false
+	}
+	return procStat{state: fields[0], pgrp: pgrp, tpgid: tpgid}, true
+}
+
+// procWchan returns the kernel function a process sleeps in, "" if unknown
+func procWchan(pid int) string {
+	data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "wchan"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// lastLines returns the last n non-empty lines of the pane, usually the agent's question
+func lastLines(content string, n int) string {
+	var lines []string
+	for _, line := range strings.Split(content, "\n") {
+		if line = strings.TrimRight(line, " "); strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
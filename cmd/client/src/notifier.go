@@ -1,12 +1,19 @@
 package src
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -14,10 +21,14 @@ import (
 type NotificationType string
 
 const (
-	TaskCompleted NotificationType = "task_completed"
-	Error         NotificationType = "error"
-	Progress      NotificationType = "progress"
-	SystemStatus  NotificationType = "system_status"
+	TaskCompleted   NotificationType = "task_completed"
+	Error           NotificationType = "error"
+	Progress        NotificationType = "progress"
+	SystemStatus    NotificationType = "system_status"
+	WaitingForInput NotificationType = "waiting_for_input" // the agent went quiet after activity
+
+	PermissionRequest NotificationType = "permission_request" // the agent asks to approve a tool
+	PermissionReply   NotificationType = "permission_reply"   // published by a device to answer it
 )
 
 // Notification notification message
@@ -34,21 +45,44 @@ type PublishRequest struct {
 	Data      map[string]interface{} `json:"data"`
 }
 
+// RegisterRequest session registration request
+type RegisterRequest struct {
+	ClientHost  string `json:"client_host"`
+	CodeCmd     string `json:"codecmd"`
+	PublicPorts []int  `json:"public_ports"`
+	TCPPorts    []int  `json:"tcp_ports"`
+}
+
+// RegisterResponse session registration response
+type RegisterResponse struct {
+	Token string `json:"token"`
+}
+
 // Notifier sends notifications to the server
 type Notifier struct {
 	serverURL    string
 	sessionID    string
 	httpClient   *http.Client
 	enabled      bool
+	authName     string
+	password     string
+	token        string // session token minted by the server on Register
+	mu           sync.RWMutex
 }
 
 // NewNotifier creates a new notifier
-func NewNotifier(serverURL, sessionID string) *Notifier {
+// insecureSkipVerify skips HTTPS certificate verification, like the piko tunnel
+func NewNotifier(serverURL, sessionID string, insecureSkipVerify bool) *Notifier {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if insecureSkipVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
 	return &Notifier{
 		serverURL: serverURL,
 		sessionID: sessionID,
 		httpClient: &http.Client{
-			Timeout: 10 * time.Second,
+			Timeout:   10 * time.Second,
+			Transport: transport,
 		},
 		enabled: true,
 	}
@@ -76,7 +110,7 @@ func (n *Notifier) Publish(notifType NotificationType, data map[string]interface
 	}
 
 	// Send POST request
-	resp, err := n.httpClient.Post(notifyURL, "application/json", bytes.NewReader(jsonData))
+	resp, err := n.post(notifyURL, jsonData)
 	if err != nil {
 		return fmt.Errorf("failed to send notification: %w", err)
 	}
@@ -92,6 +126,207 @@ func (n *Notifier) Publish(notifType NotificationType, data map[string]interface
 	return nil
 }
 
+// Register reports this session's client details to the server registry
+// publicPorts are the attached ports the server should serve without authentication,
+// tcpPorts the ports forwarded as raw TCP
+func (n *Notifier) Register(codeCmd string, publicPorts, tcpPorts []int) error {
+	hostname, _ := os.Hostname()
+
+	registerURL := fmt.Sprintf("%s/api/v1/sessions/%s/register", n.serverURL, n.sessionID)
+	jsonData, err := json.Marshal(RegisterRequest{
+		ClientHost:  hostname,
+		CodeCmd:     codeCmd,
+		PublicPorts: publicPorts,
+		TCPPorts:    tcpPorts,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal registration: %w", err)
+	}
+
+	// Registration always proves ownership with the terminal credential
+	req, err := http.NewRequest(http.MethodPost, registerURL, bytes.NewReader(jsonData))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(n.authName, n.password)
+
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to register session: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("registration failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var result RegisterResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to decode registration response: %w", err)
+	}
+
+	n.mu.Lock()
+	n.token = result.Token
+	n.mu.Unlock()
+
+	log.Printf("✓ Session registered: session=%s", n.sessionID)
+	return nil
+}
+
+// Subscribe streams the session's notifications of the given types from the
+// server and calls handle for each, until the stream ends or ctx is cancelled
+func (n *Notifier) Subscribe(ctx context.Context, eventTypes []NotificationType, handle func(Notification)) error {
+	events := make([]string, len(eventTypes))
+	for i, t := range eventTypes {
+		events[i] = string(t)
+	}
+	streamURL := fmt.Sprintf("%s/api/v1/notifications/stream?session_id=%s&events=%s",
+		n.serverURL, url.QueryEscape(n.sessionID), url.QueryEscape(strings.Join(events, ",")))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, streamURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if token := n.Token(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else {
+		req.SetBasicAuth(n.authName, n.password)
+	}
+
+	// No timeout, the stream stays open
+	streamClient := &http.Client{Transport: n.httpClient.Transport}
+	resp, err := streamClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to open notification stream: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("notification stream failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	// Events are "id:", "event:" and "data:" lines ended by a blank line, data is the notification
+	var data strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if value, ok := strings.CutPrefix(line, "data:"); ok {
+			data.WriteString(strings.TrimPrefix(value, " "))
+			continue
+		}
+		if line != "" || data.Len() == 0 {
+			continue
+		}
+
+		var notif Notification
+		if err := json.Unmarshal([]byte(data.String()), &notif); err != nil {
+			log.Printf("Invalid notification in stream: %v", err)
+		} else {
+			handle(notif)
+		}
+		data.Reset()
+	}
+
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("notification stream failed: %w", err)
+	}
+	return fmt.Errorf("notification stream closed by the server")
+}
+
+// post sends an authenticated JSON request: the session token once
+// registered, otherwise the terminal basic-auth credential.
+// A rejected token is re-issued with the credential and the request retried once.
+func (n *Notifier) post(url string, jsonData []byte) (*http.Response, error) {
+	token := n.Token()
+	resp, err := n.postWith(url, jsonData, token)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || token == "" || n.password == "" {
+		return resp, err
+	}
+	resp.Body.Close()
+
+	// The server restarted or the session reconnected since the token was issued
+	token, err = n.IssueToken()
+	if err != nil {
+		return nil, err
+	}
+	return n.postWith(url, jsonData, token)
+}
+
+// postWith sends a JSON request with the given session token, or the
+// terminal basic-auth credential when token is empty
+func (n *Notifier) postWith(url string, jsonData []byte, token string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else {
+		req.SetBasicAuth(n.authName, n.password)
+	}
+
+	return n.httpClient.Do(req)
+}
+
+// IssueToken exchanges the terminal credential for the current session token
+func (n *Notifier) IssueToken() (string, error) {
+	tokenURL := fmt.Sprintf("%s/api/v1/sessions/%s/token", n.serverURL, n.sessionID)
+	req, err := http.NewRequest(http.MethodPost, tokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(n.authName, n.password)
+
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch session token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return "", fmt.Errorf("token request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var result RegisterResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode token response: %w", err)
+	}
+
+	n.SetToken(result.Token)
+	return result.Token, nil
+}
+
+// SetCredential sets the terminal basic-auth credential used to prove session ownership
+func (n *Notifier) SetCredential(authName, password string) {
+	n.authName = authName
+	n.password = password
+}
+
+// SetToken sets the session token of an already registered session
+func (n *Notifier) SetToken(token string) {
+	n.mu.Lock()
+	n.token = token
+	n.mu.Unlock()
+}
+
+// Token returns the session token received on registration
+func (n *Notifier) Token() string {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.token
+}
+
 // PublishTaskCompleted sends a task completion notification
 func (n *Notifier) PublishTaskCompleted(taskName, output string) error {
 	return n.Publish(TaskCompleted, map[string]interface{}{
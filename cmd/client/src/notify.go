@@ -0,0 +1,96 @@
+package src
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// notificationTypePattern matches the types accepted by clauded notify, e.g. "task_completed" or "build.failed"
+var notificationTypePattern = regexp.MustCompile(`^[a-z0-9_.-]{1,64}$`)
+
+// NotifyOptions is a notification published with clauded notify
+type NotifyOptions struct {
+	Session string // session ID, detected when run inside a session
+	Type    string // notification type, e.g. task_completed
+	Title   string
+	Body    string
+	Data    []string // extra key=value fields
+}
+
+// Notify publishes a notification on behalf of a running session, using the
+// remote and session token saved in its session file
+func Notify(opts NotifyOptions) error {
+	if !notificationTypePattern.MatchString(opts.Type) {
+		return fmt.Errorf("invalid notification type %q", opts.Type)
+	}
+	if opts.Title == "" && opts.Body == "" && len(opts.Data) == 0 {
+		return fmt.Errorf("--title, --body or --data is required")
+	}
+
+	sessionID := opts.Session
+	if sessionID == "" {
+		sessionID = detectSession()
+	}
+	if sessionID == "" {
+		return fmt.Errorf("not running inside a clauded session, pass --session")
+	}
+
+	info, err := loadSessionInfo(sessionID)
+	if err != nil {
+		return fmt.Errorf("session %s not found: %w", sessionID, err)
+	}
+	if !isProcessRunning(info.PID) {
+		return fmt.Errorf("session %s is not running", sessionID)
+	}
+	if info.Token == "" {
+		return fmt.Errorf("session %s has not registered with the server yet", sessionID)
+	}
+
+	data := map[string]interface{}{
+		"title":     opts.Title,
+		"message":   opts.Body,
+		"source":    "notify",
+		"timestamp": time.Now().Format(time.RFC3339),
+	}
+	// Fill in the fields the built-in types are read by
+	switch NotificationType(opts.Type) {
+	case TaskCompleted:
+		data["task_name"] = opts.Title
+		data["output"] = opts.Body
+	case Error:
+		data["error"] = opts.Title
+		data["details"] = opts.Body
+	}
+	for _, kv := range opts.Data {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("invalid --data %q, expected key=value", kv)
+		}
+		data[key] = value
+	}
+
+	notifier := NewNotifier(info.Config.GetHTTPURL(), sessionID, info.Config.InsecureSkipVerify)
+	notifier.SetToken(info.Token)
+	return notifier.Publish(NotificationType(opts.Type), data)
+}
+
+// detectSession finds the session a command runs in: $CLAUDED_SESSION, set
+// for the agent, or else the tmux session the agent runs in
+func detectSession() string {
+	if sessionID := os.Getenv("CLAUDED_SESSION"); sessionID != "" {
+		return sessionID
+	}
+	if os.Getenv("TMUX") == "" {
+		return ""
+	}
+
+	out, err := exec.Command("tmux", "display-message", "-p", "#S").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
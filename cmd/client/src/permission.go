@@ -0,0 +1,388 @@
+package src
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// promptWindow is how many non-empty lines from the bottom of the screen a
+// prompt may end at, prompts scrolled further up were already answered
+const promptWindow = 6
+
+// promptBorder matches box-drawing characters around an agent's prompt
+var promptBorder = regexp.MustCompile(`^[\s│┃|╭╮╰╯┌┐└┘─━═]+|[\s│┃|╭╮╰╯┌┐└┘─━═]+$`)
+
+// promptProfile recognises an agent's tool approval prompt on screen
+type promptProfile struct {
+	question       *regexp.Regexp // the prompt's question
+	option         *regexp.Regexp // an option below it: number and label
+	requireOptions bool           // a question without options is not a prompt
+	digitSelects   bool           // typing an option's number selects it, no Enter needed
+}
+
+// promptProfiles are the approval prompts of the supported codecmds
+var promptProfiles = map[string]promptProfile{
+	// │ Bash command
+	// │   npm test
+	// │ Do you want to proceed?
+	// │ ❯ 1. Yes
+	// │   2. Yes, and don't ask again for npm test commands in /src
+	// │   3. No, and tell Claude what to do differently (esc)
+	"claude": {
+		question:       regexp.MustCompile(`^Do you want to .+\?$`),
+		option:         regexp.MustCompile(`^(?:[❯›>]\s*)?(\d+)\.\s+(.+)$`),
+		requireOptions: true,
+		digitSelects:   true,
+	},
+	// │ Allow execution of: 'rm'?
+	// │ ● 1. Yes, allow once
+	// │   2. Yes, allow always
+	// │   3. No, suggest changes (esc)
+	"gemini": {
+		question:       regexp.MustCompile(`^(?:Allow .+\?|Apply this change\?|Do you want to proceed\?)$`),
+		option:         regexp.MustCompile(`^(?:[●○❯›>]\s*)?(\d+)\.\s+(.+)$`),
+		requireOptions: true,
+		digitSelects:   true,
+	},
+}
+
+// defaultPromptProfile matches generic approval questions, answered with y/n or a number and Enter
+var defaultPromptProfile = promptProfile{
+	question: regexp.MustCompile(`(?i)^.*\b(?:allow|approve|permit|proceed|do you want to)\b.*\?\s*(?:[\[(][yn]/[yn][\])])?:?$`),
+	option:   regexp.MustCompile(`^(?:[●○❯›>*]\s*)?(\d+)[.)]\s+(.+)$`),
+}
+
+// PermissionPrompt is a tool approval prompt on the agent's screen
+type PermissionPrompt struct {
+	ID       string         `json:"prompt_id"` // new for every prompt shown, replies must quote it
+	Tool     string         `json:"tool"`      // e.g. "Bash command"
+	Command  string         `json:"command"`   // what the tool wants to run or change
+	Question string         `json:"question"`
+	Options  []PromptOption `json:"options"`
+}
+
+// PromptOption is a numbered answer of a prompt
+type PromptOption struct {
+	Number int    `json:"number"`
+	Label  string `json:"label"`
+}
+
+// fingerprint identifies a prompt by its content, to tell a new prompt from the same one still on screen
+func (p *PermissionPrompt) fingerprint() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n%s\n%s", p.Tool, p.Command, p.Question)
+	for _, o := range p.Options {
+		fmt.Fprintf(&b, "\n%d %s", o.Number, o.Label)
+	}
+	return b.String()
+}
+
+// parse finds an approval prompt at the bottom of the screen
+func (pp promptProfile) parse(content string) (*PermissionPrompt, bool) {
+	var lines []string
+	for _, line := range strings.Split(content, "\n") {
+		lines = append(lines, strings.TrimSpace(promptBorder.ReplaceAllString(line, "")))
+	}
+
+	// The last question on screen
+	q := -1
+	for i := len(lines) - 1; i >= 0; i-- {
+		if pp.question.MatchString(lines[i]) {
+			q = i
+			break
+		}
+	}
+	if q < 0 {
+		return nil, false
+	}
+
+	prompt := &PermissionPrompt{Question: lines[q]}
+	end := q
+	for i := q + 1; i < len(lines); i++ {
+		if lines[i] == "" {
+			continue
+		}
+		m := pp.option.FindStringSubmatch(lines[i])
+		if m == nil {
+			break
+		}
+		number, _ := strconv.Atoi(m[1])
+		prompt.Options = append(prompt.Options, PromptOption{Number: number, Label: m[2]})
+		end = i
+	}
+	if pp.requireOptions && len(prompt.Options) == 0 {
+		return nil, false
+	}
+
+	// Only a prompt waiting at the bottom of the screen counts
+	below := 0
+	for _, line := range lines[end+1:] {
+		if line != "" {
+			below++
+		}
+	}
+	if below > promptWindow {
+		return nil, false
+	}
+
+	// The tool and its command are above the question, up to the top of the box.
+	// Without a box the lines above are just earlier output.
+	var above []string
+	raw := strings.Split(content, "\n")
+	boxed := strings.ContainsAny(raw[q], "│┃")
+	for i := q - 1; boxed && i >= 0 && len(above) < 10; i-- {
+		if lines[i] == "" {
+			// An empty line that had a border is the box's top edge
+			if strings.ContainsAny(raw[i], "╭┌─━═") {
+				break
+			}
+			continue
+		}
+		above = append([]string{lines[i]}, above...)
+	}
+	if len(above) > 0 {
+		prompt.Tool = above[0]
+		prompt.Command = strings.Join(above[1:], "\n")
+	}
+	return prompt, true
+}
+
+// replyKeys returns the tmux keys answering a prompt: "approve" picks the first
+// option (or y), "deny" the last option starting with No (or n, Escape), a number that option
+func (pp promptProfile) replyKeys(prompt *PermissionPrompt, reply string) ([]string, error) {
+	reply = strings.ToLower(strings.TrimSpace(reply))
+
+	if len(prompt.Options) == 0 {
+		switch reply {
+		case "approve", "yes", "y":
+			return []string{"y", "Enter"}, nil
+		case "deny", "no", "n":
+			return []string{"n", "Enter"}, nil
+		}
+		return nil, fmt.Errorf("invalid reply %q, expected approve or deny", reply)
+	}
+
+	var number int
+	switch reply {
+	case "approve", "yes", "y":
+		number = prompt.Options[0].Number
+	case "deny", "no", "n":
+		for _, o := range prompt.Options {
+			if strings.HasPrefix(strings.ToLower(o.Label), "no") {
+				number = o.Number
+			}
+		}
+		if number == 0 {
+			return []string{"Escape"}, nil
+		}
+	default:
+		n, err := strconv.Atoi(reply)
+		if err != nil {
+			return nil, fmt.Errorf("invalid reply %q, expected approve, deny or an option number", reply)
+		}
+		for _, o := range prompt.Options {
+			if o.Number == n {
+				number = n
+			}
+		}
+		if number == 0 {
+			return nil, fmt.Errorf("prompt has no option %d", n)
+		}
+	}
+
+	if pp.digitSelects {
+		return []string{strconv.Itoa(number)}, nil
+	}
+	return []string{strconv.Itoa(number), "Enter"}, nil
+}
+
+// PermissionWatcher publishes permission_request when the agent shows a tool
+// approval prompt, and types permission_reply answers from the server into the pane
+type PermissionWatcher struct {
+	sessionName string
+	codeCmd     string
+	profile     promptProfile
+	notifier    *Notifier
+	ctx         context.Context
+	interval    time.Duration
+
+	mu       sync.Mutex
+	current  *PermissionPrompt // prompt on screen, nil if none
+	answered bool              // a reply to current was typed
+}
+
+// NewPermissionWatcher creates a permission prompt watcher for a tmux session
+func NewPermissionWatcher(sessionName, codeCmd string, notifier *Notifier, ctx context.Context) *PermissionWatcher {
+	profile, ok := promptProfiles[codeCmd]
+	if !ok {
+		profile = defaultPromptProfile
+	}
+	return &PermissionWatcher{
+		sessionName: sessionName,
+		codeCmd:     codeCmd,
+		profile:     profile,
+		notifier:    notifier,
+		ctx:         ctx,
+		interval:    time.Second,
+	}
+}
+
+// Start polls the pane for prompts and listens for replies until the context is cancelled
+func (pw *PermissionWatcher) Start() error {
+	go pw.listenReplies()
+
+	ticker := time.NewTicker(pw.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-pw.ctx.Done():
+			return nil
+		case <-ticker.C:
+			pw.check()
+		}
+	}
+}
+
+// check publishes a prompt that newly appeared on screen
+func (pw *PermissionWatcher) check() {
+	prompt, ok := pw.visiblePrompt()
+
+	pw.mu.Lock()
+	if !ok {
+		pw.current = nil
+		pw.mu.Unlock()
+		return
+	}
+	if pw.current != nil && pw.current.fingerprint() == prompt.fingerprint() {
+		pw.mu.Unlock()
+		return
+	}
+	prompt.ID = newPromptID()
+	pw.current = prompt
+	pw.answered = false
+	pw.mu.Unlock()
+
+	message := fmt.Sprintf("%s asks for permission: %s", pw.codeCmd, prompt.Question)
+	if prompt.Tool != "" {
+		message = fmt.Sprintf("%s wants to use %s", pw.codeCmd, prompt.Tool)
+		if command, _, _ := strings.Cut(prompt.Command, "\n"); command != "" {
+			message += ": " + command
+		}
+	}
+	log.Printf("🔐 Permission prompt: %s", message)
+
+	err := pw.notifier.Publish(PermissionRequest, map[string]interface{}{
+		"prompt_id": prompt.ID,
+		"tool":      prompt.Tool,
+		"command":   prompt.Command,
+		"question":  prompt.Question,
+		"options":   prompt.Options,
+		"message":   message,
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+	if err != nil {
+		log.Printf("Failed to send permission notification: %v", err)
+	}
+}
+
+// listenReplies follows the server's permission_reply notifications, reconnecting when the stream drops
+func (pw *PermissionWatcher) listenReplies() {
+	failing := false
+	for {
+		// The stream needs the token from registration
+		if pw.notifier.Token() != "" {
+			err := pw.notifier.Subscribe(pw.ctx, []NotificationType{PermissionReply}, pw.handleReply)
+			if err != nil && !failing {
+				log.Printf("⚠️  Permission replies unavailable, retrying: %v", err)
+			}
+			failing = err != nil
+		}
+
+		select {
+		case <-pw.ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// handleReply types a reply into the pane, if the prompt it answers is still on screen
+func (pw *PermissionWatcher) handleReply(notif Notification) {
+	promptID, _ := notif.Data["prompt_id"].(string)
+	reply := fmt.Sprint(notif.Data["reply"])
+
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+
+	current := pw.current
+	if current == nil || current.ID != promptID || pw.answered {
+		log.Printf("⚠️  Ignoring reply to permission prompt %q, it is no longer waiting", promptID)
+		return
+	}
+	// The screen may have changed since the last check
+	if prompt, ok := pw.visiblePrompt(); !ok || prompt.fingerprint() != current.fingerprint() {
+		log.Printf("⚠️  Ignoring reply to permission prompt %q, it is no longer on screen", promptID)
+		return
+	}
+
+	keys, err := pw.profile.replyKeys(current, reply)
+	if err != nil {
+		log.Printf("⚠️  Ignoring reply to permission prompt %q: %v", promptID, err)
+		return
+	}
+	if err := pw.sendKeys(keys); err != nil {
+		log.Printf("❌ Failed to type permission reply: %v", err)
+		return
+	}
+	pw.answered = true
+
+	log.Printf("🔐 Permission prompt %s answered: %s", promptID, reply)
+	go pw.notifier.Publish(SystemStatus, map[string]interface{}{
+		"event":     "permission_answered",
+		"prompt_id": promptID,
+		"reply":     reply,
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
+
+// visiblePrompt returns the approval prompt on screen, if any
+func (pw *PermissionWatcher) visiblePrompt() (*PermissionPrompt, bool) {
+	output, err := exec.Command("tmux", "capture-pane", "-t", pw.sessionName, "-p").Output()
+	if err != nil {
+		return nil, false
+	}
+	return pw.profile.parse(string(output))
+}
+
+// sendKeys types keys into the pane, Enter and Escape as keys, anything else literally
+func (pw *PermissionWatcher) sendKeys(keys []string) error {
+	for _, key := range keys {
+		args := []string{"send-keys", "-t", pw.sessionName}
+		if key == "Enter" || key == "Escape" {
+			args = append(args, key)
+		} else {
+			args = append(args, "-l", key)
+		}
+		if out, err := exec.Command("tmux", args...).CombinedOutput(); err != nil {
+			return fmt.Errorf("tmux send-keys: %v: %s", err, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
+
+// newPromptID returns a random prompt ID
+func newPromptID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,324 @@
+package src
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"clauded-client/src/platform"
+)
+
+// PortWatcher finds TCP ports opened by the agent's processes (e.g. a dev
+// server started by Claude) and announces or attaches them. Linux only, it
+// reads /proc/net/tcp{,6} and matches socket inodes against /proc/<pid>/fd.
+type PortWatcher struct {
+	sm         *ServiceManager
+	ctx        context.Context
+	interval   time.Duration
+	autoAttach bool
+	seen       map[int]bool // listening ports already announced
+	attached   map[int]bool // ports attached by the watcher, detached again when closed
+}
+
+// listenSocket is a listening TCP socket owned by one of the agent's processes
+type listenSocket struct {
+	port    int
+	pid     int
+	command string
+}
+
+// NewPortWatcher creates a port watcher for a session
+func NewPortWatcher(sm *ServiceManager, autoAttach bool) *PortWatcher {
+	return &PortWatcher{
+		sm:         sm,
+		ctx:        sm.ctx,
+		interval:   3 * time.Second,
+		autoAttach: autoAttach,
+		seen:       make(map[int]bool),
+		attached:   make(map[int]bool),
+	}
+}
+
+// Start polls for listening ports until the context is cancelled
+func (pw *PortWatcher) Start() error {
+	if !platform.IsLinux() {
+		log.Printf("Port watcher is only supported on Linux")
+		return nil
+	}
+
+	// Ports listening before the agent started are not its dev servers
+	for _, s := range pw.scan() {
+		pw.seen[s.port] = true
+	}
+
+	ticker := time.NewTicker(pw.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-pw.ctx.Done():
+			return nil
+		case <-ticker.C:
+			pw.check()
+		}
+	}
+}
+
+// check announces new listening ports and detaches closed auto-attached ones
+func (pw *PortWatcher) check() {
+	current := make(map[int]bool)
+	for _, s := range pw.scan() {
+		current[s.port] = true
+		if pw.seen[s.port] {
+			continue
+		}
+		pw.seen[s.port] = true
+		pw.portOpened(s)
+	}
+
+	for port := range pw.seen {
+		if current[port] {
+			continue
+		}
+		delete(pw.seen, port)
+		if pw.attached[port] {
+			delete(pw.attached, port)
+			if err := pw.sm.detachPort(port); err != nil {
+				log.Printf("Failed to detach closed port %d: %v", port, err)
+			}
+		}
+	}
+}
+
+// portOpened attaches a new port if enabled and publishes where it can be reached
+func (pw *PortWatcher) portOpened(s listenSocket) {
+	log.Printf("🔎 Port %d opened by %s (pid %d)", s.port, s.command, s.pid)
+
+	cfg := pw.sm.config
+	pw.sm.mu.Lock()
+	alreadyAttached := cfg.IsAttachedPort(s.port) || containsPort(cfg.TCPPorts, s.port)
+	pw.sm.mu.Unlock()
+
+	attached := alreadyAttached
+	if !alreadyAttached && pw.autoAttach {
+		if err := pw.sm.attachPort(ControlRequest{Action: "attach", Port: s.port}); err != nil {
+			log.Printf("Failed to attach port %d: %v", s.port, err)
+		} else {
+			pw.attached[s.port] = true
+			attached = true
+		}
+	}
+
+	data := map[string]interface{}{
+		"event":     "port_opened",
+		"port":      s.port,
+		"pid":       s.pid,
+		"command":   s.command,
+		"attached":  attached,
+		"timestamp": time.Now().Format(time.RFC3339),
+	}
+	if attached {
+		pw.sm.mu.Lock()
+		path := cfg.PortPath(s.port)
+		pw.sm.mu.Unlock()
+		url := strings.TrimRight(cfg.GetHTTPURL(), "/") + path
+		data["url"] = url
+		data["message"] = fmt.Sprintf("%s is listening on port %d: %s", s.command, s.port, url)
+	} else {
+		data["message"] = fmt.Sprintf("%s is listening on port %d, forward it with: clauded session attach-port %s %d",
+			s.command, s.port, cfg.GetSessionID(), s.port)
+	}
+
+	if err := pw.sm.notifier.Publish(SystemStatus, data); err != nil {
+		log.Printf("Failed to send port notification: %v", err)
+	}
+}
+
+// scan returns the listening sockets owned by the agent and its descendants
+func (pw *PortWatcher) scan() []listenSocket {
+	owners := socketOwners(descendants(pw.rootPIDs()))
+	if len(owners) == 0 {
+		return nil
+	}
+
+	var sockets []listenSocket
+	for _, file := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		for inode, port := range listeningSockets(file) {
+			pid, ok := owners[inode]
+			if !ok || port == pw.sm.config.GottyPort {
+				continue
+			}
+			sockets = append(sockets, listenSocket{port: port, pid: pid, command: processName(pid)})
+		}
+	}
+	return sockets
+}
+
+// rootPIDs returns the processes the agent runs under: the tmux pane when the
+// session uses tmux, and this process for agents started directly by gotty
+func (pw *PortWatcher) rootPIDs() []int {
+	roots := []int{os.Getpid()}
+
+	out, err := exec.Command("tmux", "list-panes", "-s", "-t", pw.sm.config.GetSessionID(), "-F", "#{pane_pid}").Output()
+	if err != nil {
+		return roots
+	}
+	for _, line := range strings.Fields(string(out)) {
+		if pid, err := strconv.Atoi(line); err == nil {
+			roots = append(roots, pid)
+		}
+	}
+	return roots
+}
+
+// descendants returns roots and all their descendant processes
+func descendants(roots []int) []int {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return roots
+	}
+
+	children := make(map[int][]int)
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		stat, err := os.ReadFile(filepath.Join("/proc", entry.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+		// Fields after the parenthesised command: state ppid ...
+		idx := strings.LastIndexByte(string(stat), ')')
+		if idx < 0 {
+			continue
+		}
+		fields := strings.Fields(string(stat[idx+1:]))
+		if len(fields) < 2 {
+			continue
+		}
+		if ppid, err := strconv.Atoi(fields[1]); err == nil {
+			children[ppid] = append(children[ppid], pid)
+		}
+	}
+
+	seen := make(map[int]bool)
+	queue := append([]int(nil), roots...)
+	var pids []int
+	for len(queue) > 0 {
+		pid := queue[0]
+		queue = queue[1:]
+		if seen[pid] {
+			continue
+		}
+		seen[pid] = true
+		pids = append(pids, pid)
+		queue = append(queue, children[pid]...)
+	}
+	return pids
+}
+
+// socketOwners maps socket inodes to the process holding them
+func socketOwners(pids []int) map[string]int {
+	owners := make(map[string]int)
+	for _, pid := range pids {
+		fdDir := filepath.Join("/proc", strconv.Itoa(pid), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			target, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil {
+				continue
+			}
+			// socket:[12345]
+			if inode, ok := strings.CutPrefix(target, "socket:["); ok {
+				owners[strings.TrimSuffix(inode, "]")] = pid
+			}
+		}
+	}
+	return owners
+}
+
+// listeningSockets parses /proc/net/tcp or tcp6 into inode -> port for
+// sockets in LISTEN state reachable on 127.0.0.1 or [::1], where attached ports are forwarded to
+func listeningSockets(file string) map[string]int {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	sockets := make(map[string]int)
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || fields[3] != "0A" {
+			continue
+		}
+		addr, portHex, ok := strings.Cut(fields[1], ":")
+		if !ok || !reachableOnLoopback(addr) {
+			continue
+		}
+		port, err := strconv.ParseUint(portHex, 16, 16)
+		if err != nil {
+			continue
+		}
+		sockets[fields[9]] = int(port)
+	}
+	return sockets
+}
+
+// reachableOnLoopback reports whether a /proc/net/tcp{,6} local address accepts
+// connections to 127.0.0.1 or [::1]: IPv4 any or loopback, IPv6 any, loopback
+// or v4-mapped loopback
+func reachableOnLoopback(addr string) bool {
+	switch len(addr) {
+	case 8:
+		// Little-endian IPv4, 0100007F is 127.0.0.1
+		return addr == "00000000" || strings.HasSuffix(addr, "7F")
+	case 32:
+		// IPv6 as four little-endian words, ::1 ends in 01000000
+		return addr == strings.Repeat("0", 32) ||
+			addr == strings.Repeat("0", 24)+"01000000" ||
+			(strings.HasPrefix(addr, "0000000000000000FFFF0000") && strings.HasSuffix(addr, "7F"))
+	}
+	return false
+}
+
+// loopbackAddr returns the address an attached port is forwarded to:
+// 127.0.0.1, or [::1] for servers that only listen on IPv6 loopback
+func loopbackAddr(port int) string {
+	ipv4 := fmt.Sprintf("127.0.0.1:%d", port)
+	if conn, err := net.DialTimeout("tcp", ipv4, time.Second); err == nil {
+		conn.Close()
+		return ipv4
+	}
+	ipv6 := fmt.Sprintf("[::1]:%d", port)
+	if conn, err := net.DialTimeout("tcp", ipv6, time.Second); err == nil {
+		conn.Close()
+		return ipv6
+	}
+	// Nothing listens yet, most servers bind IPv4
+	return ipv4
+}
+
+// processName returns the command name of a process
+func processName(pid int) string {
+	comm, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "comm"))
+	if err != nil {
+		return "unknown"
+	}
+	return strings.TrimSpace(string(comm))
+}
@@ -7,7 +7,9 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -21,21 +23,36 @@ import (
 
 // ServiceManager service manager
 type ServiceManager struct {
-	config   *Config
-	ctx      context.Context
-	cancel   context.CancelFunc
-	notifier *Notifier
+	config    *Config
+	ctx       context.Context
+	cancel    context.CancelFunc
+	notifier  *Notifier
+	info      *SessionInfo
+	listeners map[int]context.CancelFunc // piko listeners of attached ports, by local port
+	mu        sync.Mutex                 // guards listeners, connection state and the attached ports in config
+	attachMu  sync.Mutex                 // serializes attach and detach requests from the control socket
+
+	endpoints      map[string]services.ConnectionState // connection state of each piko endpoint
+	connection     services.ConnectionState            // connected while all endpoints are
+	disconnectedAt time.Time
+
+	hooks       bool // Claude Code hooks send notifications, tmux output isn't scraped
+	permissions bool        // the permission watcher publishes tool approval prompts
+	idleTimer   *time.Timer // pending waiting_for_input from Claude's idle notification
 }
 
 // NewServiceManager creates a new service manager
 func NewServiceManager(config *Config) *ServiceManager {
 	ctx, cancel := context.WithCancel(context.Background())
-	notifier := NewNotifier(config.GetHTTPURL(), config.GetSessionID())
+	notifier := NewNotifier(config.GetHTTPURL(), config.GetSessionID(), config.InsecureSkipVerify)
+	notifier.SetCredential(config.AuthName, config.Password)
 	return &ServiceManager{
-		config:   config,
-		ctx:      ctx,
-		cancel:   cancel,
-		notifier: notifier,
+		config:    config,
+		ctx:       ctx,
+		cancel:    cancel,
+		notifier:  notifier,
+		listeners: make(map[int]context.CancelFunc),
+		endpoints: make(map[string]services.ConnectionState),
 	}
 }
 
@@ -84,12 +101,14 @@ func (sm *ServiceManager) startServices() error {
 	// Start piko service for gotty
 	g.Add(func() error {
 		pikoConfig := services.PikoConfig{
-			RemoteURL:   sm.config.GetPikoAddress(),
-			EndpointID:  sm.config.GetSessionID(),
-			LocalAddr:   fmt.Sprintf("127.0.0.1:%d", sm.config.GottyPort),
-			Timeout:     30 * time.Second,
-			GracePeriod: 30 * time.Second,
-			AccessLog:   false,
+			RemoteURL:     sm.config.GetPikoAddress(),
+			EndpointID:    sm.config.GetSessionID(),
+			LocalAddr:     fmt.Sprintf("127.0.0.1:%d", sm.config.GottyPort),
+			Timeout:       30 * time.Second,
+			GracePeriod:   30 * time.Second,
+			AccessLog:     false,
+			Token:         sm.config.PikoToken,
+			OnStateChange: sm.onPikoState,
 		}
 		pikoService := services.NewPikoService(pikoConfig, sm.ctx, sm.config.InsecureSkipVerify)
 		err := pikoService.Start()
@@ -104,34 +123,25 @@ func (sm *ServiceManager) startServices() error {
 		// piko service will stop automatically when context is cancelled
 	})
 
-	// Start piko services for attach-ports
-	for _, port := range sm.config.AttachPorts {
-		// Capture port variable for goroutine
-		attachPort := port
-		g.Add(func() error {
-			// Use endpoint ID format: {sessionID}-{port}
-			endpointID := fmt.Sprintf("%s-%d", sm.config.GetSessionID(), attachPort)
-			pikoConfig := services.PikoConfig{
-				RemoteURL:   sm.config.GetPikoAddress(),
-				EndpointID:  endpointID,
-				LocalAddr:   fmt.Sprintf("127.0.0.1:%d", attachPort),
-				Timeout:     30 * time.Second,
-				GracePeriod: 30 * time.Second,
-				AccessLog:   false,
+	// Start piko services for attach-ports and attach-tcp,
+	// more can be attached at runtime through the control socket
+	g.Add(func() error {
+		for _, port := range sm.config.AttachPorts {
+			if err := sm.startPortListener(port, false); err != nil {
+				return err
 			}
-			pikoService := services.NewPikoService(pikoConfig, sm.ctx, sm.config.InsecureSkipVerify)
-			err := pikoService.Start()
-			if err != nil {
-				fmt.Printf("Failed to start piko for port %d: %v\n", attachPort, err)
+		}
+		for _, port := range sm.config.TCPPorts {
+			if err := sm.startPortListener(port, true); err != nil {
 				return err
 			}
-			// Wait for context cancellation
-			<-sm.ctx.Done()
-			return sm.ctx.Err()
-		}, func(error) {
-			// piko service will stop automatically when context is cancelled
-		})
-	}
+		}
+		// Wait for context cancellation
+		<-sm.ctx.Done()
+		return sm.ctx.Err()
+	}, func(error) {
+		// piko services stop when the context is cancelled
+	})
 
 	// Start gotty service
 	g.Add(func() error {
@@ -168,12 +178,25 @@ func (sm *ServiceManager) startServices() error {
 		sm.cancel()
 	})
 
-	// Start notification watcher (only if tmux is available)
+	// Start notification watcher (only if tmux is available and hooks are not installed)
 	tmuxService := NewTmuxService(sm.config.GetSessionID())
-	if tmuxService.IsAvailable() {
+	if tmuxService.IsAvailable() && !sm.hooks {
 		g.Add(func() error {
 			fmt.Printf("🔔 Starting notification watcher...\n")
-			watcher := NewTmuxWatcher(sm.config.GetSessionID(), sm.notifier, sm.ctx)
+			detector, paths, err := LoadTaskDetector(sm.notifier, sm.config.CodeCmd)
+			if err != nil {
+				log.Printf("⚠️  Failed to load detection rules, using the built-in ones: %v", err)
+				detector = NewTaskDetector(sm.notifier)
+			} else if len(paths) > 0 {
+				log.Printf("Detection rules: %s", strings.Join(paths, ", "))
+			}
+			// Rules from the working directory come with the project, make them visible
+			for _, path := range paths {
+				if filepath.Dir(path) == ProjectRulesDir() {
+					fmt.Printf("⚠️  Using project detection rules: %s\n", path)
+				}
+			}
+			watcher := NewTmuxWatcher(sm.config.GetSessionID(), detector, sm.notifier, sm.ctx)
 			if err := watcher.Start(); err != nil {
 				log.Printf("Notification watcher stopped: %v", err)
 			}
@@ -183,6 +206,36 @@ func (sm *ServiceManager) startServices() error {
 		})
 	}
 
+	// Notify when the agent goes quiet waiting for input,
+	// with hooks Claude Code's idle notification reports it instead
+	if tmuxService.IsAvailable() && sm.config.IdleTimeout > 0 && !sm.hooks {
+		g.Add(func() error {
+			return NewIdleWatcher(sm.config.GetSessionID(), sm.config.CodeCmd, sm.config.IdleTimeout, sm.notifier, sm.ctx).Start()
+		}, func(error) {
+			// Watcher stops when the context is cancelled
+		})
+	}
+
+	// Publish tool approval prompts and type the replies sent back,
+	// the hook's own permission notification is then dropped
+	if tmuxService.IsAvailable() && sm.config.PermissionPrompts {
+		sm.permissions = true
+		g.Add(func() error {
+			return NewPermissionWatcher(sm.config.GetSessionID(), sm.config.CodeCmd, sm.notifier, sm.ctx).Start()
+		}, func(error) {
+			// Watcher stops when the context is cancelled
+		})
+	}
+
+	// Watch for ports opened by the agent
+	if sm.config.WatchPorts || sm.config.AutoAttach {
+		g.Add(func() error {
+			return NewPortWatcher(sm, sm.config.AutoAttach).Start()
+		}, func(error) {
+			// Watcher stops when the context is cancelled
+		})
+	}
+
 	// 24-hour timeout - only enable when AutoExit is true
 	if sm.config.AutoExit {
 		g.Add(func() error {
@@ -205,21 +258,41 @@ func (sm *ServiceManager) startServices() error {
 
 	sessionID := sm.config.GetSessionID()
 
+	// Control socket for clauded session attach-port/detach-port and clauded hook
+	if ln, err := listenControl(sessionID); err != nil {
+		fmt.Printf("Warning: runtime port attach disabled: %v\n", err)
+	} else {
+		g.Add(func() error {
+			return sm.serveControl(ln)
+		}, func(error) {
+			ln.Close()
+		})
+	}
+
 	// Save session info
-	info := &SessionInfo{
-		SessionID: sessionID,
-		PID:       os.Getpid(),
-		Port:      sm.config.GottyPort,
-		StartTime: time.Now(),
-		Config:    sm.config,
-	}
-	if err := saveSessionInfo(info); err != nil {
+	sm.info = &SessionInfo{
+		SessionID:       sessionID,
+		PID:             os.Getpid(),
+		Port:            sm.config.GottyPort,
+		StartTime:       time.Now(),
+		Config:          sm.config,
+		Connection:      string(services.StateConnecting),
+		ConnectionSince: time.Now(),
+	}
+	if err := saveSessionInfo(sm.info); err != nil {
 		fmt.Printf("Warning: failed to save session info: %v\n", err)
 	}
 	defer removeSessionInfo(sessionID)
+	if sm.hooks {
+		defer removeHooks(sessionID)
+	}
 
 	fmt.Printf("✅ Services started successfully!\n")
 
+	// Register with the server session registry to obtain the notification token.
+	// Retry while piko and gotty come up; older servers don't support it.
+	go sm.registerWithRetry(10)
+
 	// Construct remote access URL
 	remoteURL := fmt.Sprintf("%s/%s", strings.TrimRight(sm.config.GetHTTPURL(), "/"), sm.config.GetSessionID())
 	fmt.Printf("🌐 Access URL: %s\n", remoteURL)
@@ -229,8 +302,20 @@ func (sm *ServiceManager) startServices() error {
 	if len(sm.config.AttachPorts) > 0 {
 		fmt.Printf("📌 Attached ports:\n")
 		for _, port := range sm.config.AttachPorts {
-			attachURL := fmt.Sprintf("%s/%s/%d", strings.TrimRight(sm.config.GetHTTPURL(), "/"), sm.config.GetSessionID(), port)
-			fmt.Printf("   - Port %d -> %s\n", port, attachURL)
+			attachURL := strings.TrimRight(sm.config.GetHTTPURL(), "/") + sm.config.PortPath(port)
+			if sm.config.IsPublicPort(port) {
+				fmt.Printf("   - Port %d -> %s (public)\n", port, attachURL)
+			} else {
+				fmt.Printf("   - Port %d -> %s\n", port, attachURL)
+			}
+		}
+	}
+
+	// Show raw TCP ports
+	if len(sm.config.TCPPorts) > 0 {
+		fmt.Printf("🔌 TCP ports:\n")
+		for _, port := range sm.config.TCPPorts {
+			fmt.Printf("   - Port %d -> clauded connect %s %d --remote %s --local <port>\n", port, sm.config.GetSessionID(), port, sm.config.Remote)
 		}
 	}
 	
@@ -245,6 +330,131 @@ func (sm *ServiceManager) startServices() error {
 	return g.Run()
 }
 
+// registerWithRetry registers the session, retrying while the terminal is not reachable yet
+func (sm *ServiceManager) registerWithRetry(attempts int) {
+	for attempt := 1; attempt <= attempts; attempt++ {
+		err := sm.register()
+		if err == nil {
+			return
+		}
+		if attempt == attempts {
+			log.Printf("Session registration failed: %v", err)
+			return
+		}
+		select {
+		case <-time.After(3 * time.Second):
+		case <-sm.ctx.Done():
+			return
+		}
+	}
+}
+
+// onPikoState tracks the connection of every piko endpoint of the session
+func (sm *ServiceManager) onPikoState(endpointID string, state services.ConnectionState, err error) {
+	sm.mu.Lock()
+	sm.endpoints[endpointID] = state
+	sm.mu.Unlock()
+
+	sm.updateConnection(endpointID, err)
+}
+
+// updateConnection recomputes the session's connection, which counts as connected
+// while all of its endpoints are, and reports drops and recoveries
+func (sm *ServiceManager) updateConnection(endpointID string, err error) {
+	sm.mu.Lock()
+	connection := services.StateConnected
+	for _, s := range sm.endpoints {
+		if s != services.StateConnected {
+			connection = services.StateDisconnected
+			break
+		}
+	}
+	previous := sm.connection
+	if connection == previous {
+		sm.mu.Unlock()
+		return
+	}
+	sm.connection = connection
+
+	now := time.Now()
+	var downtime time.Duration
+	if connection == services.StateDisconnected {
+		sm.disconnectedAt = now
+	} else if previous == services.StateDisconnected {
+		downtime = now.Sub(sm.disconnectedAt).Round(time.Second)
+	}
+	if sm.info != nil {
+		sm.info.Connection = string(connection)
+		sm.info.ConnectionSince = now
+		if err := saveSessionInfo(sm.info); err != nil {
+			log.Printf("Failed to save session info: %v", err)
+		}
+	}
+	sm.mu.Unlock()
+
+	switch {
+	case connection == services.StateDisconnected:
+		log.Printf("⚠️  Lost connection to the server (endpoint %s), reconnecting...", endpointID)
+		data := map[string]interface{}{
+			"event":     "disconnected",
+			"endpoint":  endpointID,
+			"message":   fmt.Sprintf("Session %s lost its connection to the server, reconnecting", sm.config.GetSessionID()),
+			"timestamp": now.Format(time.RFC3339),
+		}
+		if err != nil {
+			data["error"] = err.Error()
+		}
+		// Best effort, the server is usually unreachable at this point
+		go sm.notifier.Publish(SystemStatus, data)
+
+	case previous == services.StateDisconnected:
+		log.Printf("✅ Reconnected to the server after %s", downtime)
+		go func() {
+			// A restarted server has forgotten the session and its token
+			sm.registerWithRetry(3)
+			sm.notifier.Publish(SystemStatus, map[string]interface{}{
+				"event":            "reconnected",
+				"downtime_seconds": int(downtime.Seconds()),
+				"message":          fmt.Sprintf("Session %s reconnected after %s", sm.config.GetSessionID(), downtime),
+				"timestamp":        time.Now().Format(time.RFC3339),
+			})
+		}()
+	}
+}
+
+// startPortListener connects a piko listener forwarding a local port.
+// It returns after the first connection attempt, the listener keeps
+// reconnecting in the background until the session stops or the port is detached.
+func (sm *ServiceManager) startPortListener(port int, tcp bool) error {
+	sm.mu.Lock()
+	endpointID := sm.config.PortEndpointID(port)
+	sm.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(sm.ctx)
+	pikoConfig := services.PikoConfig{
+		RemoteURL:     sm.config.GetPikoAddress(),
+		EndpointID:    endpointID,
+		LocalAddr:     loopbackAddr(port),
+		Timeout:       30 * time.Second,
+		GracePeriod:   30 * time.Second,
+		AccessLog:     false,
+		Token:         sm.config.PikoToken,
+		TCP:           tcp,
+		OnStateChange: sm.onPikoState,
+	}
+	pikoService := services.NewPikoService(pikoConfig, ctx, sm.config.InsecureSkipVerify)
+	if err := pikoService.Start(); err != nil {
+		cancel()
+		fmt.Printf("Failed to start piko for port %d: %v\n", port, err)
+		return err
+	}
+
+	sm.mu.Lock()
+	sm.listeners[port] = cancel
+	sm.mu.Unlock()
+	return nil
+}
+
 // handleSignals handles OS signals for graceful shutdown
 func (sm *ServiceManager) handleSignals() error {
 	c := make(chan os.Signal, 1)
@@ -296,11 +506,27 @@ func (sm *ServiceManager) getClaudeCodeCommand() (string, []string) {
 		args = append(args, flagParts...)
 	}
 
+	// Claude Code reports completions and prompts through hooks
+	if sm.config.CodeCmd == "claude" {
+		if path, err := installHooks(sm.config.GetSessionID()); err != nil {
+			fmt.Printf("Warning: failed to install Claude Code hooks, falling back to output scraping: %v\n", err)
+		} else {
+			args = append(args, "--settings", path)
+			sm.hooks = true
+			fmt.Printf("🔔 Claude Code hooks installed: %s\n", path)
+		}
+	}
+
 	// Use tmux for persistent sessions
 	// According to gotty docs: "gotty tmux new -A -s gotty top"
+	// Let clauded notify find the session from inside the agent
+	sessionEnv := "CLAUDED_SESSION=" + sm.config.GetSessionID()
+	os.Setenv("CLAUDED_SESSION", sm.config.GetSessionID())
+
 	tmuxService := NewTmuxService(sm.config.GetSessionID())
 	if tmuxService.IsAvailable() {
-		tmuxPath, tmuxArgs, err := tmuxService.WrapCommand(command, args, sm.config.EnvVars)
+		envVars := append(append([]string(nil), sm.config.EnvVars...), sessionEnv)
+		tmuxPath, tmuxArgs, err := tmuxService.WrapCommand(command, args, envVars)
 		if err == nil {
 			return tmuxPath, tmuxArgs
 		}
@@ -336,6 +562,11 @@ func (sm *ServiceManager) daemonize() error {
 	// Create a new process that will run in background
 	cmd := exec.Command(execPath, args...)
 
+	// Pass the piko token via environment so it doesn't show up in ps
+	if sm.config.PikoToken != "" {
+		cmd.Env = append(os.Environ(), "PIKO_TOKEN="+sm.config.PikoToken)
+	}
+
 	// Open log file for child process
 	logFile, err := os.OpenFile(logFilePath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
 	if err != nil {
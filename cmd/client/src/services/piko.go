@@ -3,22 +3,53 @@ package services
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"math/rand"
+	"net"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/andydunstall/piko/agent/config"
 	"github.com/andydunstall/piko/agent/reverseproxy"
-	"github.com/andydunstall/piko/client"
+	"github.com/andydunstall/piko/agent/tcpproxy"
 	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/pkg/websocket"
+	"github.com/andydunstall/yamux"
+	"github.com/golang-jwt/jwt/v5"
 )
 
+// ConnectionState is the state of a piko endpoint's upstream connection
+type ConnectionState string
+
+const (
+	StateConnecting   ConnectionState = "connecting"
+	StateConnected    ConnectionState = "connected"
+	StateDisconnected ConnectionState = "disconnected"
+)
+
+// Reconnect backoff bounds, the delay doubles after each failed attempt
+const (
+	minReconnectBackoff = 1 * time.Second
+	maxReconnectBackoff = 60 * time.Second
+)
+
+// upstreamTokenTTL is the lifetime of the upstream tokens signed with a shared secret
+const upstreamTokenTTL = 5 * time.Minute
+
 // PikoService manages the piko reverse proxy service
 type PikoService struct {
 	config               PikoConfig
 	ctx                  context.Context
 	insecureSkipVerify   bool
+	listenURL            string
+	tlsConfig            *tls.Config
+	listener             config.ListenerConfig
+	logger               log.Logger
+	state                ConnectionState
+	mu                   sync.Mutex
 }
 
 // PikoConfig holds the configuration for piko service
@@ -29,6 +60,10 @@ type PikoConfig struct {
 	Timeout      time.Duration
 	GracePeriod  time.Duration
 	AccessLog    bool
+	Token        string // PIKO_TOKEN: shared secret or pre-signed JWT
+	TCP          bool   // forward raw TCP connections instead of HTTP
+	// OnStateChange is called when the endpoint connects, disconnects or reconnects
+	OnStateChange func(endpointID string, state ConnectionState, err error)
 }
 
 // NewPikoService creates a new piko service
@@ -37,6 +72,7 @@ func NewPikoService(config PikoConfig, ctx context.Context, insecureSkipVerify b
 		config:             config,
 		ctx:                ctx,
 		insecureSkipVerify: insecureSkipVerify,
+		state:              StateConnecting,
 	}
 }
 
@@ -59,7 +95,7 @@ func (ps *PikoService) Start() error {
 		Listeners: []config.ListenerConfig{
 			{
 				EndpointID: ps.config.EndpointID,
-				Protocol:   config.ListenerProtocolHTTP,
+				Protocol:   ps.protocol(),
 				Addr:       ps.config.LocalAddr,
 				AccessLog:  ps.config.AccessLog,
 				Timeout:    30 * time.Second,
@@ -99,35 +135,200 @@ func (ps *PikoService) Start() error {
 		fmt.Printf(" (HTTPS certificate verification skipped)")
 	}
 
-	// Create upstream client
-	upstream := &client.Upstream{
-		URL:       connectURL,
-		TLSConfig: tlsConfig,
-		Logger:    logger.WithSubsystem("client"),
+	// Check the upstream authentication token, a fresh one is signed for every connection
+	if _, err := ps.upstreamToken(); err != nil {
+		return fmt.Errorf("failed to create piko token: %w", err)
 	}
 
-	// Create connections for each listener
-	for _, listenerConfig := range conf.Listeners {
-		ln, err := upstream.Listen(ps.ctx, listenerConfig.EndpointID)
-		if err != nil {
-			return fmt.Errorf("failed to listen on endpoint %s: %w", listenerConfig.EndpointID, err)
+	ps.listenURL = upstreamListenURL(connectURL, ps.config.EndpointID)
+	ps.tlsConfig = tlsConfig
+	ps.listener = conf.Listeners[0]
+	ps.logger = logger
+
+	// The first connection is attempted synchronously so configuration errors
+	// surface, an unreachable server is retried in the background like a lost connection
+	ln, err := ps.listen()
+	var retryable *websocket.RetryableError
+	if errors.As(err, &retryable) {
+		fmt.Printf(" server unreachable, retrying in the background: %v\n", err)
+		ps.setState(StateDisconnected, err)
+		go ps.supervise(nil)
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to listen on endpoint %s: %w", ps.listener.EndpointID, err)
+	}
+	ps.setState(StateConnected, nil)
+
+	go ps.supervise(ln)
+
+	fmt.Print(" done\n")
+	return nil
+}
+
+// supervise serves the endpoint and reconnects whenever the listener is lost,
+// until the service is stopped (session exit or detached port).
+// A nil listener connects first.
+func (ps *PikoService) supervise(ln net.Listener) {
+	for {
+		if ln == nil {
+			if ln = ps.reconnect(); ln == nil {
+				return
+			}
+			fmt.Printf("✅ piko endpoint %s connected\n", ps.listener.EndpointID)
+			ps.setState(StateConnected, nil)
+		}
+
+		err := ps.serve(ln)
+		if ps.ctx.Err() != nil {
+			return
 		}
+		fmt.Printf("⚠️  piko endpoint %s lost: %v\n", ps.listener.EndpointID, err)
+		ps.setState(StateDisconnected, err)
+		ln = nil
+	}
+}
 
-		// Create HTTP proxy server
-		metrics := reverseproxy.NewMetrics("proxy")
-		server := reverseproxy.NewServer(listenerConfig, metrics, logger)
-		if server == nil {
-			return fmt.Errorf("failed to create HTTP proxy server")
+// serve proxies connections from the listener until it fails or the service stops
+func (ps *PikoService) serve(ln net.Listener) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ps.ctx.Done():
+		case <-done:
 		}
+		ln.Close()
+	}()
 
-		// Start proxy server
-		go func() {
-			if err := server.Serve(ln); err != nil && err != context.Canceled {
-				fmt.Printf("proxy server runtime error: %v\n", err)
-			}
-		}()
+	// Raw TCP listeners forward connections as is
+	if ps.listener.Protocol == config.ListenerProtocolTCP {
+		return tcpproxy.NewServer(ps.listener, ps.logger).Serve(ln)
 	}
 
-	fmt.Print(" done\n")
-	return nil
+	// Create HTTP proxy server
+	metrics := reverseproxy.NewMetrics("proxy")
+	server := reverseproxy.NewServer(ps.listener, metrics, ps.logger)
+	if server == nil {
+		return fmt.Errorf("failed to create HTTP proxy server")
+	}
+	return server.Serve(ln)
+}
+
+// reconnect listens on the endpoint again with exponential backoff and jitter.
+// It returns nil once the service is stopped.
+func (ps *PikoService) reconnect() net.Listener {
+	backoff := minReconnectBackoff
+	for {
+		// Equal jitter: wait between half and the full backoff
+		delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		select {
+		case <-time.After(delay):
+		case <-ps.ctx.Done():
+			return nil
+		}
+
+		ln, err := ps.listen()
+		if err == nil {
+			return ln
+		}
+		if ps.ctx.Err() != nil {
+			return nil
+		}
+		fmt.Printf("❌ piko endpoint %s reconnect failed, retrying in ~%s: %v\n", ps.listener.EndpointID, backoff, err)
+
+		backoff *= 2
+		if backoff > maxReconnectBackoff {
+			backoff = maxReconnectBackoff
+		}
+	}
+}
+
+// State returns the current connection state of the endpoint
+func (ps *PikoService) State() ConnectionState {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.state
+}
+
+// setState records a state change and reports it to OnStateChange
+func (ps *PikoService) setState(state ConnectionState, err error) {
+	ps.mu.Lock()
+	if ps.state == state || ps.ctx.Err() != nil {
+		ps.mu.Unlock()
+		return
+	}
+	ps.state = state
+	ps.mu.Unlock()
+
+	if ps.config.OnStateChange != nil {
+		ps.config.OnStateChange(ps.config.EndpointID, state, err)
+	}
+}
+
+// listen connects the endpoint to the piko upstream once. The returned
+// listener fails on the first connection error instead of reconnecting behind
+// our back, so supervise sees every drop and tracks the state itself.
+func (ps *PikoService) listen() (net.Listener, error) {
+	token, err := ps.upstreamToken()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := websocket.Dial(ps.ctx, ps.listenURL,
+		websocket.WithToken(token),
+		websocket.WithTLSConfig(ps.tlsConfig),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Multiplexed streams are the incoming connections, as with piko's own client
+	sess, err := yamux.Client(conn, yamux.DefaultConfig())
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return sess, nil
+}
+
+// upstreamListenURL returns the WebSocket URL an endpoint listens on
+func upstreamListenURL(base *url.URL, endpointID string) string {
+	u := *base
+	u.Path += "/piko/v1/upstream/" + endpointID
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	}
+	return u.String()
+}
+
+// protocol returns the listener protocol, HTTP unless TCP was requested
+func (ps *PikoService) protocol() config.ListenerProtocol {
+	if ps.config.TCP {
+		return config.ListenerProtocolTCP
+	}
+	return config.ListenerProtocolHTTP
+}
+
+// upstreamToken returns the token presented to the piko upstream.
+// A value that is already a JWT is used as is, otherwise it is treated as
+// the server's shared secret and used to sign a short-lived token limited to
+// this endpoint. It only has to be valid while connecting, the server keeps
+// established upstream connections after it expires.
+func (ps *PikoService) upstreamToken() (string, error) {
+	token := ps.config.Token
+	if token == "" || strings.Count(token, ".") == 2 {
+		return token, nil
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iat": now.Unix(),
+		"exp": now.Add(upstreamTokenTTL).Unix(),
+		"piko": map[string]interface{}{
+			"endpoints": []string{ps.config.EndpointID},
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(token))
 }
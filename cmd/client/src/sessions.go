@@ -15,11 +15,14 @@ import (
 
 // SessionInfo stores information about a running daemon session
 type SessionInfo struct {
-	SessionID string    `json:"session_id"`
-	PID       int       `json:"pid"`
-	Port      int       `json:"port"`
-	StartTime time.Time `json:"start_time"`
-	Config    *Config   `json:"config"`
+	SessionID       string    `json:"session_id"`
+	PID             int       `json:"pid"`
+	Port            int       `json:"port"`
+	StartTime       time.Time `json:"start_time"`
+	Config          *Config   `json:"config"`
+	Connection      string    `json:"connection,omitempty"`       // connecting, connected or disconnected
+	ConnectionSince time.Time `json:"connection_since,omitempty"` // last connection state change
+	Token           string    `json:"token,omitempty"`            // session token, used by clauded notify
 }
 
 // getSessionDir returns the directory where session info is stored
@@ -40,7 +43,8 @@ func saveSessionInfo(info *SessionInfo) error {
 		return err
 	}
 
-	return os.WriteFile(file, data, 0644)
+	// Only the session owner may read the session token
+	return os.WriteFile(file, data, 0600)
 }
 
 // loadSessionInfo loads session information from disk
@@ -119,8 +123,8 @@ func ListSessions() {
 	})
 
 	fmt.Println("Active clauded sessions:")
-	fmt.Printf("%-10s %-12s %-8s %-8s %-25s %s\n", "SESSION ID", "PASSWORD", "PID", "PORT", "STARTED", "FLAGS")
-	fmt.Println(strings.Repeat("-", 95))
+	fmt.Printf("%-10s %-12s %-8s %-8s %-25s %-22s %s\n", "SESSION ID", "PASSWORD", "PID", "PORT", "STARTED", "STATE", "FLAGS")
+	fmt.Println(strings.Repeat("-", 118))
 
 	for _, s := range sessions {
 		flags := s.Config.Flags
@@ -131,12 +135,19 @@ func ListSessions() {
 		if password == "" {
 			password = "(none)"
 		}
-		fmt.Printf("%-10s %-12s %-8d %-8d %-25s %s\n",
+		state := s.Connection
+		if state == "" {
+			state = "unknown"
+		} else if !s.ConnectionSince.IsZero() {
+			state += " " + time.Since(s.ConnectionSince).Round(time.Second).String()
+		}
+		fmt.Printf("%-10s %-12s %-8d %-8d %-25s %-22s %s\n",
 			s.SessionID,
 			password,
 			s.PID,
 			s.Port,
 			s.StartTime.Format("2006-01-02 15:04:05"),
+			state,
 			flags)
 	}
 }
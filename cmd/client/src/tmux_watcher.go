@@ -18,8 +18,7 @@ type TmuxWatcher struct {
 }
 
 // NewTmuxWatcher creates a new tmux watcher
-func NewTmuxWatcher(sessionName string, notifier *Notifier, ctx context.Context) *TmuxWatcher {
-	detector := NewTaskDetector(notifier)
+func NewTmuxWatcher(sessionName string, detector *TaskDetector, notifier *Notifier, ctx context.Context) *TmuxWatcher {
 	return &TmuxWatcher{
 		sessionName: sessionName,
 		notifier:    notifier,
@@ -4,12 +4,14 @@ import (
 	"context"
 	"fmt"
 	stdlog "log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"clauded-server/certs"
 	"clauded-server/config"
 	"clauded-server/handlers"
 	"clauded-server/notification"
@@ -22,16 +24,30 @@ import (
 	"github.com/oklog/run"
 )
 
+// pikoAdminAddr is the piko admin API, polled for connected endpoints
+const pikoAdminAddr = "127.0.0.1:7070"
+
 func main() {
 	// Load configuration
 	cfg := config.Load()
 
 	// Create managers
 	sessionMgr := session.NewManager()
-	notificationSvc := notification.NewService()
+	webhookCfg := notification.DefaultWebhookConfig()
+	webhookCfg.MaxAttempts = cfg.WebhookMaxAttempts
+	webhookCfg.QueueSize = cfg.WebhookQueueSize
+	webhookCfg.DisableAfter = time.Duration(cfg.WebhookDisableMinutes) * time.Minute
+	history, err := notification.NewHistory(cfg.NotificationHistorySize,
+		time.Duration(cfg.NotificationHistoryTTLHours)*time.Hour, cfg.NotificationHistoryDB)
+	if err != nil {
+		stdlog.Fatalf("❌ Failed to open notification history: %v", err)
+	}
+	defer history.Close()
+	notificationSvc := notification.NewService(webhookCfg, history)
 
 	// Create proxy manager (piko proxy port is 8023)
-	proxyMgr := proxy.NewManager(8023, cfg.PikoUpstreamPort)
+	proxyMgr := proxy.NewManager(8023, cfg.PikoUpstreamPort, cfg.EnableTLS && cfg.PikoUpstreamTLS)
+	proxyMgr.SetRewriteBody(cfg.RewritePortBody)
 
 	// Create HTTP handler
 	handler := handlers.NewHandler(cfg, sessionMgr, notificationSvc, proxyMgr)
@@ -42,13 +58,24 @@ func main() {
 		Handler: handler.SetupRoutes(),
 	}
 
+	// Load TLS certificate (reloaded on file change or SIGHUP)
+	var certReloader *certs.Reloader
+	if cfg.EnableTLS {
+		reloader, err := certs.NewReloader(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			stdlog.Fatalf("❌ Failed to load TLS certificate: %v", err)
+		}
+		certReloader = reloader
+		httpServer.TLSConfig = certReloader.TLSConfig()
+	}
+
 	var g run.Group
 
 	// Create context for signal handling
 	ctx, cancel := context.WithCancel(context.Background())
 
 	// Start Piko server as a Go library
-	pikoSrv := startPikoServer(cfg)
+	pikoSrv := startPikoServer(cfg, proxyMgr.ProxySecret())
 
 	g.Add(func() error {
 		stdlog.Printf("Starting piko server on upstream port %d, proxy port 8023\n", cfg.PikoUpstreamPort)
@@ -72,8 +99,15 @@ func main() {
 
 	// HTTP server
 	g.Add(func() error {
-		stdlog.Printf("Starting HTTP server on port %d\n", cfg.ListenPort)
-		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if certReloader != nil {
+			stdlog.Printf("Starting HTTPS server on port %d\n", cfg.ListenPort)
+			err = httpServer.ListenAndServeTLS("", "")
+		} else {
+			stdlog.Printf("Starting HTTP server on port %d\n", cfg.ListenPort)
+			err = httpServer.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			return fmt.Errorf("HTTP server failed: %w", err)
 		}
 		return nil
@@ -83,6 +117,49 @@ func main() {
 		httpServer.Shutdown(shutdownCtx)
 	})
 
+	if certReloader != nil {
+		// HTTP -> HTTPS redirect server
+		if cfg.HTTPRedirectPort > 0 {
+			redirectServer := &http.Server{
+				Addr:    fmt.Sprintf(":%d", cfg.HTTPRedirectPort),
+				Handler: redirectToHTTPS(cfg.ListenPort),
+			}
+			g.Add(func() error {
+				stdlog.Printf("Starting HTTP redirect server on port %d\n", cfg.HTTPRedirectPort)
+				if err := redirectServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+					return fmt.Errorf("HTTP redirect server failed: %w", err)
+				}
+				return nil
+			}, func(error) {
+				redirectServer.Close()
+			})
+		}
+
+		// Certificate hot-reload on file change or SIGHUP
+		g.Add(func() error {
+			go certReloader.Watch(ctx, 30*time.Second)
+
+			hup := make(chan os.Signal, 1)
+			signal.Notify(hup, syscall.SIGHUP)
+			defer signal.Stop(hup)
+
+			for {
+				select {
+				case <-hup:
+					if err := certReloader.Reload(); err != nil {
+						stdlog.Printf("⚠️  SIGHUP: %v\n", err)
+						continue
+					}
+					stdlog.Println("🔐 TLS certificate reloaded (SIGHUP)")
+				case <-ctx.Done():
+					return nil
+				}
+			}
+		}, func(error) {
+			cancel()
+		})
+	}
+
 	// Notification service
 	g.Add(func() error {
 		notificationSvc.Start()
@@ -92,6 +169,32 @@ func main() {
 		notificationSvc.Stop()
 	})
 
+	// Session registry: follow piko endpoints and drop stale sessions
+	g.Add(func() error {
+		watcher := session.NewEndpointWatcher(sessionMgr, pikoAdminAddr, 5*time.Second)
+		go watcher.Run(ctx)
+
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				// Ended sessions take their notification history with them
+				for _, id := range sessionMgr.Cleanup(10 * time.Minute) {
+					notificationSvc.ForgetSession(id)
+				}
+				notificationSvc.PruneHistory(func(id string) bool {
+					_, live := sessionMgr.Get(id)
+					return live
+				})
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}, func(error) {
+		cancel()
+	})
+
 	// Signal handling
 	g.Add(func() error {
 		c := make(chan os.Signal, 1)
@@ -113,7 +216,7 @@ func main() {
 }
 
 // startPikoServer starts piko server as a Go library
-func startPikoServer(cfg *config.Config) *pikoserver.Server {
+func startPikoServer(cfg *config.Config, proxySecret string) *pikoserver.Server {
 	// Build piko server configuration
 	upstreamAddr := fmt.Sprintf(":%d", cfg.PikoUpstreamPort)
 	proxyAddr := ":8023"
@@ -129,9 +232,35 @@ func startPikoServer(cfg *config.Config) *pikoserver.Server {
 	pikoCfg.Cluster.Gossip.BindAddr = ":0"    // Disable gossip
 	pikoCfg.Upstream.BindAddr = upstreamAddr
 	pikoCfg.Proxy.BindAddr = proxyAddr
-	pikoCfg.Admin.BindAddr = ":7070"
+	pikoCfg.Admin.BindAddr = pikoAdminAddr
 	pikoCfg.GracePeriod = 30 * time.Second
 
+	// Serve the upstream port over TLS too. Piko loads the certificate once,
+	// so the upstream port only picks up a renewed certificate on restart.
+	if cfg.EnableTLS && cfg.PikoUpstreamTLS {
+		pikoCfg.Upstream.TLS.Cert = cfg.TLSCertFile
+		pikoCfg.Upstream.TLS.Key = cfg.TLSKeyFile
+	}
+
+	// Require upstream (agent) connections to present a JWT signed with
+	// PIKO_TOKEN. Without it anyone could register any endpoint ID.
+	if cfg.PikoToken != "" {
+		pikoCfg.Upstream.Auth.HMACSecretKey = cfg.PikoToken
+		// Clients sign short-lived tokens on every (re)connect, an
+		// established connection outlives the token it was opened with
+		pikoCfg.Upstream.Auth.DisableDisconnectOnExpiry = true
+		stdlog.Println("🔐 Piko upstream authentication enabled")
+	} else {
+		stdlog.Println("⚠️  PIKO_TOKEN not set, piko upstream authentication disabled")
+	}
+
+	// Only this server may use the proxy port, with tokens limited to the
+	// endpoint it routed each request to. Without this, a request smuggling
+	// piko's /_piko/v1/tcp route could tunnel to any session's raw TCP port.
+	// Long-lived WebSockets are not cut off when their token expires.
+	pikoCfg.Proxy.Auth.HMACSecretKey = proxySecret
+	pikoCfg.Proxy.Auth.DisableDisconnectOnExpiry = true
+
 	// Validate config
 	if err := pikoCfg.Validate(); err != nil {
 		stdlog.Fatalf("❌ Invalid piko configuration: %v", err)
@@ -145,3 +274,17 @@ func startPikoServer(cfg *config.Config) *pikoserver.Server {
 
 	return pikoSrv
 }
+
+// redirectToHTTPS redirects plain HTTP requests to the HTTPS listener
+func redirectToHTTPS(httpsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, fmt.Sprintf("%d", httpsPort))
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
@@ -1,8 +1,10 @@
 package config
 
 import (
+	"log"
 	"os"
 	"strconv"
+	"strings"
 )
 
 // Config server configuration
@@ -13,6 +15,36 @@ type Config struct {
 	EnableTLS        bool
 	TLSCertFile      string
 	TLSKeyFile       string
+	HTTPRedirectPort int  // plain HTTP port redirecting to HTTPS (0 disables)
+	PikoUpstreamTLS  bool // serve the piko upstream port over TLS as well
+	AdminToken       string
+	SessionDomain    string // wildcard domain for <session>.<domain> routing (empty disables)
+	RewritePortBody  bool   // rewrite root-relative URLs in HTML/CSS of attached ports
+
+	// Webhook delivery
+	WebhookMaxAttempts    int
+	WebhookQueueSize      int
+	WebhookDisableMinutes int // disable webhooks failing continuously this long
+
+	// Browser login
+	LoginSessionHours   int    // login cookie lifetime
+	CookieSecret        string // signs login cookies, random per process when empty
+	LoginCookieSameSite string // "lax" or "none" (needed when embedded cross-site, HTTPS only)
+
+	// Brute-force protection
+	AuthMaxFailuresPerIP      int // failed attempts before an IP is locked out (0 disables)
+	AuthMaxFailuresPerSession int // failed attempts before a session is locked out (0 disables)
+	AuthLockoutMinutes        int
+	TrustedProxies            []string // reverse proxies allowed to set X-Forwarded-For
+
+	// SSE notification streams
+	SSEMaxStreams       int // concurrent streams per session (0 means unlimited)
+	SSEHeartbeatSeconds int // interval between keep-alive comments
+
+	// Notification history
+	NotificationHistorySize     int    // notifications kept per session
+	NotificationHistoryDB       string // BoltDB file, empty keeps history in memory
+	NotificationHistoryTTLHours int    // drop history of sessions quiet this long, 0 keeps it
 }
 
 // Load loads configuration from environment variables
@@ -24,6 +56,31 @@ func Load() *Config {
 		EnableTLS:        getEnvBool("ENABLE_TLS", false),
 		TLSCertFile:      getEnvOrDefault("TLS_CERT_FILE", ""),
 		TLSKeyFile:       getEnvOrDefault("TLS_KEY_FILE", ""),
+		HTTPRedirectPort: getEnvInt("HTTP_REDIRECT_PORT", 0),
+		PikoUpstreamTLS:  getEnvBool("PIKO_UPSTREAM_TLS", false),
+		AdminToken:       getEnvOrDefault("ADMIN_TOKEN", ""),
+		SessionDomain:    getEnvOrDefault("SESSION_DOMAIN", ""),
+		RewritePortBody:  getEnvBool("REWRITE_PORT_BODY", false),
+
+		WebhookMaxAttempts:    getEnvPositiveInt("WEBHOOK_MAX_ATTEMPTS", 8),
+		WebhookQueueSize:      getEnvPositiveInt("WEBHOOK_QUEUE_SIZE", 100),
+		WebhookDisableMinutes: getEnvInt("WEBHOOK_DISABLE_MINUTES", 1440),
+
+		LoginSessionHours:   getEnvPositiveInt("LOGIN_SESSION_HOURS", 24),
+		CookieSecret:        getEnvOrDefault("COOKIE_SECRET", ""),
+		LoginCookieSameSite: getEnvOrDefault("LOGIN_COOKIE_SAMESITE", "lax"),
+
+		AuthMaxFailuresPerIP:      getEnvInt("AUTH_MAX_FAILURES_PER_IP", 10),
+		AuthMaxFailuresPerSession: getEnvInt("AUTH_MAX_FAILURES_PER_SESSION", 30),
+		AuthLockoutMinutes:        getEnvPositiveInt("AUTH_LOCKOUT_MINUTES", 15),
+		TrustedProxies:            strings.Split(getEnvOrDefault("TRUSTED_PROXIES", "127.0.0.1,::1"), ","),
+
+		SSEMaxStreams:       getEnvInt("SSE_MAX_STREAMS", 10),
+		SSEHeartbeatSeconds: getEnvInt("SSE_HEARTBEAT_SECONDS", 15),
+
+		NotificationHistorySize:     getEnvPositiveInt("NOTIFICATION_HISTORY_SIZE", 200),
+		NotificationHistoryDB:       getEnvOrDefault("NOTIFICATION_HISTORY_DB", ""),
+		NotificationHistoryTTLHours: getEnvInt("NOTIFICATION_HISTORY_TTL_HOURS", 72),
 	}
 }
 
@@ -34,12 +91,24 @@ func getEnvOrDefault(key, defaultValue string) string {
 	return defaultValue
 }
 
+// getEnvInt reads a non-negative integer, invalid values fall back to the default
 func getEnvInt(key string, defaultValue int) int {
 	if value := os.Getenv(key); value != "" {
-		if intValue, err := strconv.Atoi(value); err == nil {
+		if intValue, err := strconv.Atoi(value); err == nil && intValue >= 0 {
 			return intValue
 		}
+		log.Printf("Ignoring invalid %s=%q, using %d", key, value, defaultValue)
+	}
+	return defaultValue
+}
+
+// getEnvPositiveInt reads a setting that 0 would break (queue and history sizes,
+// durations), invalid values fall back to the default
+func getEnvPositiveInt(key string, defaultValue int) int {
+	if value := getEnvInt(key, defaultValue); value > 0 {
+		return value
 	}
+	log.Printf("Ignoring %s=0, using %d", key, defaultValue)
 	return defaultValue
 }
 
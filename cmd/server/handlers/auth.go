@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"clauded-server/notification"
+	"clauded-server/proxy"
+
+	"github.com/gin-gonic/gin"
+)
+
+// authorizeSession checks that the request proves ownership of a session,
+// either with the per-session token (Bearer header or ?token=, since
+// EventSource cannot set headers) or with the session's basic-auth
+// credential, which is verified against the client's terminal.
+// On failure it writes the error response and returns false.
+func (h *Handler) authorizeSession(c *gin.Context, sessionID string) bool {
+	authorization := c.GetHeader("Authorization")
+
+	token := c.Query("token")
+	if strings.HasPrefix(authorization, "Bearer ") {
+		token = strings.TrimPrefix(authorization, "Bearer ")
+	}
+	if token != "" {
+		if h.sessionManager.VerifyToken(sessionID, token) {
+			return true
+		}
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid session token"})
+		return false
+	}
+
+	if strings.HasPrefix(authorization, "Basic ") {
+		return h.verifyBasicAuth(c, sessionID, authorization)
+	}
+
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "session token or credential required"})
+	return false
+}
+
+// verifyBasicAuth checks a basic-auth header against the session's terminal
+func (h *Handler) verifyBasicAuth(c *gin.Context, sessionID, authorization string) bool {
+	ok, err := h.checkCredential(c, sessionID, authorization)
+	if errors.Is(err, errLockedOut) {
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return false
+	}
+	if errors.Is(err, proxy.ErrSessionNotConnected) {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return false
+	}
+	if err != nil {
+		log.Printf("Failed to verify credential for session %s: %v", sessionID, err)
+		c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{"error": "failed to verify credential"})
+		return false
+	}
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid credential"})
+		return false
+	}
+	return true
+}
+
+// requireCredential guards proxied session traffic with the terminal credential.
+// Browsers are sent to the login page, other clients get a basic-auth challenge.
+// On failure it writes the response and returns false.
+func (h *Handler) requireCredential(c *gin.Context, sessionID string) bool {
+	// Without a password the terminal accepts an empty credential
+	ok, err := h.checkCredential(c, sessionID, c.GetHeader("Authorization"))
+	if errors.Is(err, errLockedOut) {
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return false
+	}
+	if errors.Is(err, proxy.ErrSessionNotConnected) {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return false
+	}
+	if err != nil {
+		log.Printf("Failed to verify credential for session %s: %v", sessionID, err)
+		c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{"error": "failed to verify credential"})
+		return false
+	}
+	if !ok && wantsLogin(c) {
+		redirectToLogin(c, sessionID)
+		return false
+	}
+	if !ok {
+		c.Header("WWW-Authenticate", fmt.Sprintf(`Basic realm="clauded %s"`, sessionID))
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return false
+	}
+	return true
+}
+
+// IssueToken exchanges the session's basic-auth credential for its token
+func (h *Handler) IssueToken(c *gin.Context) {
+	sessionID := c.Param("id")
+	if !h.verifyBasicAuth(c, sessionID, c.GetHeader("Authorization")) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"session_id": sessionID,
+		"token":      h.sessionManager.EnsureToken(sessionID),
+	})
+}
+
+// errLockedOut is returned while the client IP or the session is locked out
+var errLockedOut = errors.New("too many failed attempts, try again later")
+
+// checkCredential verifies a credential against the session's terminal,
+// trusting recently verified credentials for credentialTTL.
+// Unknown and disconnected sessions return proxy.ErrSessionNotConnected, which
+// is not counted as a failure. Failed attempts count towards the per-IP and
+// per-session lockouts. A session lockout does not apply to credentials the
+// session accepted before, so an attacker cannot lock the owner out.
+func (h *Handler) checkCredential(c *gin.Context, sessionID, authorization string) (bool, error) {
+	ipKey, sessionKey := "ip:"+c.ClientIP(), "session:"+sessionID
+	if until := h.limiter.lockedUntil(ipKey); !until.IsZero() {
+		c.Header("Retry-After", strconv.Itoa(int(time.Until(until).Seconds())+1))
+		return false, errLockedOut
+	}
+
+	if h.credentials.valid(sessionID, authorization) {
+		return true, nil
+	}
+
+	if until := h.limiter.lockedUntil(sessionKey); !until.IsZero() && !h.credentials.known(sessionID, authorization) {
+		c.Header("Retry-After", strconv.Itoa(int(time.Until(until).Seconds())+1))
+		return false, errLockedOut
+	}
+
+	ok, err := h.proxyManager.VerifyCredential(c.Request.Context(), sessionID, authorization)
+	if err != nil {
+		return false, err
+	}
+
+	if ok {
+		h.credentials.add(sessionID, authorization)
+		h.limiter.reset(sessionKey)
+		return true, nil
+	}
+
+	// An empty credential only probes whether the terminal has a password
+	if authorization != "" {
+		h.recordAuthFailure(c, sessionID, ipKey, sessionKey)
+	}
+	return false, nil
+}
+
+// recordAuthFailure counts a failed attempt and tells the session owner about new lockouts
+func (h *Handler) recordAuthFailure(c *gin.Context, sessionID, ipKey, sessionKey string) {
+	ip := c.ClientIP()
+	log.Printf("⚠️  Failed authentication: session=%s, ip=%s", sessionID, ip)
+
+	if h.limiter.fail(ipKey, h.config.AuthMaxFailuresPerIP) {
+		log.Printf("🔐 IP locked out: ip=%s, session=%s", ip, sessionID)
+		h.notifyLockout(sessionID, "ip", ip)
+	}
+	if h.limiter.fail(sessionKey, h.config.AuthMaxFailuresPerSession) {
+		log.Printf("🔐 Session locked out: session=%s, last ip=%s", sessionID, ip)
+		h.notifyLockout(sessionID, "session", ip)
+	}
+}
+
+// notifyLockout publishes a system_status notification to the session owner
+// Nothing is published for sessions the server does not know
+func (h *Handler) notifyLockout(sessionID, scope, ip string) {
+	if _, exists := h.sessionManager.Get(sessionID); !exists {
+		return
+	}
+
+	lockout := time.Duration(h.config.AuthLockoutMinutes) * time.Minute
+	h.notificationSvc.Publish(sessionID, notification.SystemStatus, map[string]interface{}{
+		"event":     "auth_lockout",
+		"scope":     scope,
+		"ip":        ip,
+		"until":     time.Now().Add(lockout).Format(time.RFC3339),
+		"message":   fmt.Sprintf("Too many failed login attempts from %s, %s locked for %s", ip, scope, lockout),
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
+
+// credentialTTL is how long a verified credential is trusted without asking the terminal again
+const credentialTTL = time.Minute
+
+// knownCredentialTTL is how long a verified credential may still be checked
+// while its session is locked out
+const knownCredentialTTL = 24 * time.Hour
+
+// credentialCache remembers when session credentials were last verified, keyed by a hash
+type credentialCache struct {
+	entries map[string]time.Time
+	mu      sync.Mutex
+}
+
+func newCredentialCache() *credentialCache {
+	return &credentialCache{
+		entries: make(map[string]time.Time),
+	}
+}
+
+func (cc *credentialCache) key(sessionID, authorization string) string {
+	sum := sha256.Sum256([]byte(sessionID + "\x00" + authorization))
+	return hex.EncodeToString(sum[:])
+}
+
+func (cc *credentialCache) valid(sessionID, authorization string) bool {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	verified, ok := cc.entries[cc.key(sessionID, authorization)]
+	return ok && time.Since(verified) < credentialTTL
+}
+
+// known reports whether the credential was accepted by the session within knownCredentialTTL
+func (cc *credentialCache) known(sessionID, authorization string) bool {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	verified, ok := cc.entries[cc.key(sessionID, authorization)]
+	return ok && time.Since(verified) < knownCredentialTTL
+}
+
+func (cc *credentialCache) add(sessionID, authorization string) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	now := time.Now()
+	for k, verified := range cc.entries {
+		if now.Sub(verified) >= knownCredentialTTL {
+			delete(cc.entries, k)
+		}
+	}
+	cc.entries[cc.key(sessionID, authorization)] = now
+}
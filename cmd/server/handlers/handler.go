@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"io"
 	"log"
 	"net/http"
@@ -14,6 +15,7 @@ import (
 	"clauded-server/proxy"
 	"clauded-server/session"
 
+	"github.com/gin-contrib/sse"
 	"github.com/gin-gonic/gin"
 )
 
@@ -22,6 +24,9 @@ type Handler struct {
 	sessionManager  *session.Manager
 	notificationSvc *notification.Service
 	proxyManager    *proxy.Manager
+	credentials     *credentialCache
+	logins          *loginStore
+	limiter         *authLimiter
 }
 
 func NewHandler(cfg *config.Config, sm *session.Manager, ns *notification.Service, pm *proxy.Manager) *Handler {
@@ -30,12 +35,24 @@ func NewHandler(cfg *config.Config, sm *session.Manager, ns *notification.Servic
 		sessionManager:  sm,
 		notificationSvc: ns,
 		proxyManager:    pm,
+		credentials:     newCredentialCache(),
+		logins:          newLoginStore(cfg.CookieSecret, time.Duration(cfg.LoginSessionHours)*time.Hour),
+		limiter:         newAuthLimiter(time.Duration(cfg.AuthLockoutMinutes) * time.Minute),
 	}
 }
 
 func (h *Handler) SetupRoutes() *gin.Engine {
 	router := gin.Default()
 
+	// Only trust X-Forwarded-For from our own reverse proxy, client IPs drive the auth lockouts
+	if err := router.SetTrustedProxies(h.config.TrustedProxies); err != nil {
+		log.Printf("⚠️  Invalid TRUSTED_PROXIES: %v", err)
+	}
+
+	// <session>.SESSION_DOMAIN and <session>-<port>.SESSION_DOMAIN,
+	// registered first so it sees every request before path routing
+	router.Use(h.SubdomainRouter)
+
 	// Health check
 	router.GET("/health", h.HealthCheck)
 
@@ -49,8 +66,26 @@ func (h *Handler) SetupRoutes() *gin.Engine {
 		api.POST("/publish", h.PublishNotification)
 		api.DELETE("/unsubscribe", h.UnsubscribeWebhook)
 		api.GET("/subscriptions", h.GetSubscriptions)
+		api.DELETE("/subscriptions/:id", h.DeleteSubscription)
+		api.GET("/dead-letters", h.GetDeadLetters)
+		api.GET("/history", h.GetHistory)
 	}
 
+	// Session registry
+	sessions := router.Group("/api/v1/sessions")
+	{
+		sessions.GET("", h.requireAdmin, h.ListSessions)
+		sessions.GET("/:id", h.requireAdmin, h.GetSession)
+		sessions.POST("/:id/register", h.RegisterSession)
+		sessions.POST("/:id/token", h.IssueToken)
+		sessions.GET("/:id/ports", h.ListPorts)
+		sessions.POST("/:id/ports/:port/share", h.SharePort)
+		sessions.DELETE("/:id/ports/:port/share", h.RevokePortShare)
+	}
+
+	// Raw TCP ports, tunnelled over WebSocket by clauded connect
+	router.GET("/_piko/v1/tcp/:endpoint", h.ConnectTCP)
+
 	// Root path "/" -> proxy to piko as "root-service"
 	router.Any("/", gin.WrapH(h.proxyManager.ProxyRootRequest()))
 
@@ -84,6 +119,31 @@ func (h *Handler) SSEStream(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "session_id is required"})
 		return
 	}
+	if !h.authorizeSession(c, sessionID) {
+		return
+	}
+
+	// ?events=task_completed,task.* or repeated ?events=, empty means all events
+	eventTypes := []notification.NotificationType{}
+	for _, param := range c.QueryArray("events") {
+		for _, e := range strings.Split(param, ",") {
+			if e = strings.TrimSpace(e); e != "" {
+				eventTypes = append(eventTypes, notification.NotificationType(e))
+			}
+		}
+	}
+
+	// Subscribe before reading the history so nothing published in between is lost
+	sub, err := h.notificationSvc.SubscribeSSE(sessionID, eventTypes, h.config.SSEMaxStreams)
+	if errors.Is(err, notification.ErrTooManyStreams) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer h.notificationSvc.Unsubscribe(sessionID, sub.ID)
 
 	// Set SSE headers
 	c.Writer.Header().Set("Content-Type", "text/event-stream")
@@ -91,43 +151,79 @@ func (h *Handler) SSEStream(c *gin.Context) {
 	c.Writer.Header().Set("Connection", "keep-alive")
 	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 
-	// Subscribe to notifications
-	ch := h.notificationSvc.SubscribeSSE(sessionID)
-	defer func() {
-		// Unsubscribe will be handled by session manager
-	}()
+	// Replay what a reconnecting client missed. Browsers send Last-Event-ID
+	// on reconnect, last_event_id covers a fresh EventSource after a reload.
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+	replayed := make(map[string]bool)
+	if lastEventID != "" {
+		for _, notif := range h.notificationSvc.History(sessionID, lastEventID) {
+			if !notification.MatchEventType(notif.Type, eventTypes) {
+				continue
+			}
+			writeSSE(c, notif)
+			replayed[notif.ID] = true
+		}
+	}
 
 	// Flush headers
 	c.Writer.Flush()
 
+	// Comment lines keep proxies from closing an idle stream, and a failed
+	// write tells us the client is gone
+	var heartbeat <-chan time.Time
+	if h.config.SSEHeartbeatSeconds > 0 {
+		ticker := time.NewTicker(time.Duration(h.config.SSEHeartbeatSeconds) * time.Second)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+
 	// Send notifications
 	c.Stream(func(w io.Writer) bool {
 		select {
-		case notif, ok := <-ch:
+		case notif, ok := <-sub.Channel:
 			if !ok {
 				return false
 			}
+			if replayed[notif.ID] {
+				return true
+			}
 
-			// Format SSE message
-			data, _ := json.Marshal(notif)
-			c.SSEvent(string(notif.Type), string(data))
+			writeSSE(c, notif)
 			return true
+		case <-heartbeat:
+			_, err := io.WriteString(w, ": ping\n\n")
+			return err == nil
 		case <-c.Request.Context().Done():
 			return false
 		}
 	})
 }
 
+// writeSSE writes a notification as an SSE event, the notification ID is the event ID
+func writeSSE(c *gin.Context, notif notification.Notification) {
+	data, _ := json.Marshal(notif)
+	c.Render(-1, sse.Event{
+		Id:    notif.ID,
+		Event: string(notif.Type),
+		Data:  string(data),
+	})
+}
+
 type SubscribeRequest struct {
 	SessionID  string                    `json:"session_id" binding:"required"`
 	WebhookURL string                    `json:"webhook_url" binding:"required"`
 	Events     []string                  `json:"events"`
+	Secret     string                    `json:"secret"` // optional, generated when empty
 }
 
 type SubscribeResponse struct {
 	SubscriptionID string `json:"subscription_id"`
 	SessionID      string `json:"session_id"`
 	WebhookURL     string `json:"webhook_url"`
+	Secret         string `json:"secret"` // HMAC key for X-Clauded-Signature
 }
 
 func (h *Handler) SubscribeWebhook(c *gin.Context) {
@@ -136,6 +232,9 @@ func (h *Handler) SubscribeWebhook(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if !h.authorizeSession(c, req.SessionID) {
+		return
+	}
 
 	// Convert string events to NotificationType
 	eventTypes := make([]notification.NotificationType, len(req.Events))
@@ -144,15 +243,26 @@ func (h *Handler) SubscribeWebhook(c *gin.Context) {
 	}
 
 	// Subscribe webhook
-	if err := h.notificationSvc.SubscribeWebhook(req.SessionID, req.WebhookURL, eventTypes); err != nil {
+	sub, err := h.notificationSvc.SubscribeWebhook(req.SessionID, req.WebhookURL, req.Secret, eventTypes)
+	if errors.Is(err, notification.ErrAlreadySubscribed) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":           err.Error(),
+			"subscription_id": sub.ID,
+		})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
 	log.Printf("Webhook subscribed: session=%s, url=%s", req.SessionID, req.WebhookURL)
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Webhook subscribed successfully",
+	c.JSON(http.StatusOK, SubscribeResponse{
+		SubscriptionID: sub.ID,
+		SessionID:      sub.SessionID,
+		WebhookURL:     sub.WebhookURL,
+		Secret:         sub.Secret(),
 	})
 }
 
@@ -168,6 +278,9 @@ func (h *Handler) PublishNotification(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if !h.authorizeSession(c, req.SessionID) {
+		return
+	}
 
 	// Publish notification to the service
 	h.notificationSvc.Publish(req.SessionID, notification.NotificationType(req.Type), req.Data)
@@ -189,20 +302,56 @@ func (h *Handler) UnsubscribeWebhook(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "session_id and webhook_url are required"})
 		return
 	}
+	if !h.authorizeSession(c, sessionID) {
+		return
+	}
+
+	if !h.notificationSvc.UnsubscribeWebhook(sessionID, webhookURL) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "subscription not found"})
+		return
+	}
 
-	// Note: We need to implement unsubscribe by webhook URL
-	// For now, just return success
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Webhook unsubscribed successfully",
 	})
 }
 
+// DeleteSubscription removes a subscription of the session named by ?session_id=.
+// The session is authorized before the ID is looked up, so unauthenticated
+// callers cannot probe which subscription IDs exist.
+func (h *Handler) DeleteSubscription(c *gin.Context) {
+	subscriptionID := c.Param("id")
+	sessionID := c.Query("session_id")
+	if sessionID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "session_id is required"})
+		return
+	}
+	if !h.authorizeSession(c, sessionID) {
+		return
+	}
+
+	if !h.notificationSvc.Unsubscribe(sessionID, subscriptionID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "subscription not found"})
+		return
+	}
+
+	log.Printf("Subscription deleted: session=%s, id=%s", sessionID, subscriptionID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":         "Subscription deleted successfully",
+		"subscription_id": subscriptionID,
+	})
+}
+
 func (h *Handler) GetSubscriptions(c *gin.Context) {
 	sessionID := c.Query("session_id")
 	if sessionID == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "session_id is required"})
 		return
 	}
+	if !h.authorizeSession(c, sessionID) {
+		return
+	}
 
 	subs := h.notificationSvc.GetSubscribers(sessionID)
 	c.JSON(http.StatusOK, gin.H{
@@ -210,6 +359,38 @@ func (h *Handler) GetSubscriptions(c *gin.Context) {
 	})
 }
 
+func (h *Handler) GetDeadLetters(c *gin.Context) {
+	sessionID := c.Query("session_id")
+	if sessionID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "session_id is required"})
+		return
+	}
+	if !h.authorizeSession(c, sessionID) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"dead_letters": h.notificationSvc.GetDeadLetters(sessionID),
+	})
+}
+
+// GetHistory returns the session's recent notifications, oldest first
+// ?after=<notification id> limits the result to newer notifications
+func (h *Handler) GetHistory(c *gin.Context) {
+	sessionID := c.Query("session_id")
+	if sessionID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "session_id is required"})
+		return
+	}
+	if !h.authorizeSession(c, sessionID) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"notifications": h.notificationSvc.History(sessionID, c.Query("after")),
+	})
+}
+
 // ProxyRequest intelligently routes requests to either port forwarding or regular session
 func (h *Handler) ProxyRequest(c *gin.Context) {
 	path := c.Request.URL.Path
@@ -217,17 +398,54 @@ func (h *Handler) ProxyRequest(c *gin.Context) {
 	path = strings.TrimPrefix(path, "/")
 	parts := strings.Split(path, "/")
 
-	// Check if this is a port forwarding request: /:session/:port/*
-	// The second segment should be a valid port number
+	// Login form: /:session/_login, /:session/_logout
 	if len(parts) >= 2 {
-		// Try to parse the second segment as a port number
-		if _, err := strconv.Atoi(parts[1]); err == nil {
-			// It's a valid port number, use port forwarding
-			h.proxyManager.ProxyPortRequest()(c.Writer, c.Request)
+		switch parts[1] {
+		case "_login":
+			h.Login(c, parts[0])
+			return
+		case "_logout":
+			h.Logout(c, parts[0])
 			return
 		}
 	}
 
+	// A login cookie stands in for the terminal credential
+	loggedIn := h.applyLogin(c, parts[0])
+
+	// Aliased port: /:session/_p/:alias/*
+	if len(parts) >= 3 && parts[1] == "_p" {
+		port, ok := h.sessionManager.ResolvePortAlias(parts[0], parts[2])
+		if !ok {
+			if !loggedIn && !h.requireCredential(c, parts[0]) {
+				return
+			}
+			c.JSON(http.StatusNotFound, gin.H{"error": "Port alias not found"})
+			return
+		}
+		h.proxyPort(c, parts[0], port, aliasPath(parts[0], parts[2]))
+		return
+	}
+
+	// Port forwarding request: /:session/:port/*
+	// Only ports the client attached, so numeric terminal paths still reach the terminal
+	if len(parts) >= 2 {
+		if port, err := strconv.Atoi(parts[1]); err == nil && h.sessionManager.IsAttachedPort(parts[0], port) && !h.sessionManager.IsTCPPort(parts[0], port) {
+			h.proxyPort(c, parts[0], port, portPath(parts[0], port))
+			return
+		}
+	}
+
+	// Check the credential here rather than letting the terminal answer,
+	// so failures are rate limited and unknown sessions look like wrong passwords
+	if !loggedIn && !h.requireCredential(c, parts[0]) {
+		return
+	}
+
+	// Record activity for the session registry, only for authorized requests
+	// so scanners cannot keep dead sessions alive
+	h.sessionManager.Touch(parts[0])
+
 	// Otherwise, use regular session proxy
 	h.proxyManager.ProxyRequest()(c.Writer, c.Request)
 }
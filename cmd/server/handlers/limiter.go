@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+)
+
+// authLimiter counts failed authentication attempts per key
+// (client IP or session ID) and locks a key out once it reaches its limit
+type authLimiter struct {
+	lockout time.Duration // lockout length, also the window failures are counted in
+	entries map[string]*failures
+	mu      sync.Mutex
+}
+
+type failures struct {
+	count       int
+	first       time.Time
+	lockedUntil time.Time
+}
+
+func newAuthLimiter(lockout time.Duration) *authLimiter {
+	return &authLimiter{
+		lockout: lockout,
+		entries: make(map[string]*failures),
+	}
+}
+
+// lockedUntil returns the latest lockout among keys, zero if none is locked
+func (l *authLimiter) lockedUntil(keys ...string) time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var until time.Time
+	now := time.Now()
+	for _, key := range keys {
+		if f, ok := l.entries[key]; ok && f.lockedUntil.After(now) && f.lockedUntil.After(until) {
+			until = f.lockedUntil
+		}
+	}
+	return until
+}
+
+// fail records a failed attempt, returns true when it locks the key out
+// A limit of 0 disables the lockout for the key
+func (l *authLimiter) fail(key string, limit int) bool {
+	if limit <= 0 {
+		return false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for k, f := range l.entries {
+		if now.Sub(f.first) > l.lockout && now.After(f.lockedUntil) {
+			delete(l.entries, k)
+		}
+	}
+
+	f, ok := l.entries[key]
+	if !ok {
+		f = &failures{first: now}
+		l.entries[key] = f
+	}
+	f.count++
+	if f.count < limit || f.lockedUntil.After(now) {
+		return false
+	}
+
+	f.lockedUntil = now.Add(l.lockout)
+	f.count = 0
+	f.first = now
+	return true
+}
+
+// reset forgets the failures of a key
+func (l *authLimiter) reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, key)
+}
@@ -0,0 +1,386 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"clauded-server/proxy"
+
+	"github.com/gin-gonic/gin"
+)
+
+// loginCookie holds a signed login ID, scoped to the session's path
+const loginCookie = "clauded_login"
+
+// csrfCookie holds the token the login form must echo back, so other sites
+// cannot submit the form on a visitor's behalf
+const csrfCookie = "clauded_csrf"
+
+// login a browser logged in to a session through the login page
+type login struct {
+	sessionID     string
+	authorization string // verified terminal credential, injected into proxied requests
+	expires       time.Time
+}
+
+// loginStore keeps browser logins. Cookies carry a random ID signed with
+// the server secret; the credential itself never leaves the server.
+type loginStore struct {
+	secret  []byte
+	ttl     time.Duration
+	entries map[string]*login
+	mu      sync.Mutex
+}
+
+// newLoginStore creates a login store, secret is random when empty
+func newLoginStore(secret string, ttl time.Duration) *loginStore {
+	key := []byte(secret)
+	if secret == "" {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			panic(err)
+		}
+	}
+	return &loginStore{
+		secret:  key,
+		ttl:     ttl,
+		entries: make(map[string]*login),
+	}
+}
+
+// create records a login and returns the cookie value
+func (ls *loginStore) create(sessionID, authorization string) (string, time.Time) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	id := hex.EncodeToString(b)
+	expires := time.Now().Add(ls.ttl)
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	now := time.Now()
+	for k, l := range ls.entries {
+		if now.After(l.expires) {
+			delete(ls.entries, k)
+		}
+	}
+	ls.entries[id] = &login{
+		sessionID:     sessionID,
+		authorization: authorization,
+		expires:       expires,
+	}
+	return id + "." + ls.sign(sessionID, id), expires
+}
+
+// lookup returns the login for a cookie value if it is valid for the session
+func (ls *loginStore) lookup(sessionID, value string) (*login, bool) {
+	id, ok := ls.verify(sessionID, value)
+	if !ok {
+		return nil, false
+	}
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	l, exists := ls.entries[id]
+	if !exists || l.sessionID != sessionID || time.Now().After(l.expires) {
+		return nil, false
+	}
+	return l, true
+}
+
+// remove deletes the login for a cookie value
+func (ls *loginStore) remove(sessionID, value string) {
+	id, ok := ls.verify(sessionID, value)
+	if !ok {
+		return
+	}
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	delete(ls.entries, id)
+}
+
+// verify checks the cookie signature and returns the login ID
+func (ls *loginStore) verify(sessionID, value string) (string, bool) {
+	id, sig, ok := strings.Cut(value, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(ls.sign(sessionID, id))) {
+		return "", false
+	}
+	return id, true
+}
+
+func (ls *loginStore) sign(sessionID, id string) string {
+	mac := hmac.New(sha256.New, ls.secret)
+	mac.Write([]byte(sessionID + "." + id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// applyLogin replaces a valid login cookie with the terminal credential it stands for
+func (h *Handler) applyLogin(c *gin.Context, sessionID string) bool {
+	cookie, err := c.Request.Cookie(loginCookie)
+	if err != nil {
+		return false
+	}
+	l, ok := h.logins.lookup(sessionID, cookie.Value)
+	if !ok {
+		return false
+	}
+
+	stripCookie(c.Request, loginCookie)
+	c.Request.Header.Set("Authorization", l.authorization)
+	return true
+}
+
+// wantsLogin reports whether the request is a browser navigation without a credential
+func wantsLogin(c *gin.Context) bool {
+	return c.Request.Method == http.MethodGet && c.GetHeader("Authorization") == "" &&
+		strings.Contains(c.GetHeader("Accept"), "text/html")
+}
+
+// redirectToLogin sends the browser to the session's login page
+func redirectToLogin(c *gin.Context, sessionID string) {
+	target := sessionPrefix(c, sessionID) + "/_login?next=" + url.QueryEscape(c.Request.URL.RequestURI())
+	c.Redirect(http.StatusFound, target)
+	c.Abort()
+}
+
+// Login serves the login form of a session and handles its submission
+func (h *Handler) Login(c *gin.Context, sessionID string) {
+	prefix := sessionPrefix(c, sessionID)
+	next := c.Query("next")
+	if c.Request.Method == http.MethodPost {
+		next = c.PostForm("next")
+	}
+	if !safeNext(next, prefix) {
+		next = prefix + "/"
+	}
+
+	if c.Request.Method != http.MethodPost {
+		h.renderLogin(c, http.StatusOK, sessionID, next, "")
+		return
+	}
+
+	if !h.checkLoginOrigin(c) {
+		h.renderLogin(c, http.StatusForbidden, sessionID, next, "Login form expired, try again")
+		return
+	}
+
+	username := c.PostForm("username")
+	password := c.PostForm("password")
+	authorization := "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+
+	ok, err := h.checkCredential(c, sessionID, authorization)
+	if errors.Is(err, errLockedOut) {
+		h.renderLogin(c, http.StatusTooManyRequests, sessionID, next, "Too many failed attempts, try again later")
+		return
+	}
+	if errors.Is(err, proxy.ErrSessionNotConnected) {
+		h.renderLogin(c, http.StatusServiceUnavailable, sessionID, next, "Session is not connected, try again later")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to verify login for session %s: %v", sessionID, err)
+		h.renderLogin(c, http.StatusBadGateway, sessionID, next, "Session is not reachable, try again later")
+		return
+	}
+	if !ok {
+		h.renderLogin(c, http.StatusUnauthorized, sessionID, next, "Invalid username or password")
+		return
+	}
+
+	value, expires := h.logins.create(sessionID, authorization)
+	http.SetCookie(c.Writer, h.loginCookie(c, sessionID, value, expires))
+	log.Printf("Login: session=%s, ip=%s", sessionID, c.ClientIP())
+
+	c.Redirect(http.StatusSeeOther, next)
+}
+
+// Logout forgets the browser's login to a session. GET shows a confirmation
+// form, only its POST logs out so other sites cannot log the visitor out.
+func (h *Handler) Logout(c *gin.Context, sessionID string) {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead:
+		h.renderLogout(c, http.StatusOK, sessionID, "")
+		return
+	case http.MethodPost:
+	default:
+		c.Header("Allow", "GET, POST")
+		c.AbortWithStatus(http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.checkLoginOrigin(c) {
+		h.renderLogout(c, http.StatusForbidden, sessionID, "Logout form expired, try again")
+		return
+	}
+
+	if cookie, err := c.Request.Cookie(loginCookie); err == nil {
+		h.logins.remove(sessionID, cookie.Value)
+	}
+
+	expired := h.loginCookie(c, sessionID, "", time.Unix(0, 0))
+	expired.MaxAge = -1
+	http.SetCookie(c.Writer, expired)
+
+	c.Redirect(http.StatusSeeOther, sessionPrefix(c, sessionID)+"/_login")
+}
+
+// safeNext reports whether next is a local path under the session prefix,
+// so the post-login redirect cannot leave the site
+func safeNext(next, prefix string) bool {
+	if strings.Contains(next, `\`) || strings.IndexFunc(next, unicode.IsControl) >= 0 {
+		return false
+	}
+	u, err := url.Parse(next)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Opaque != "" {
+		return false
+	}
+	return strings.HasPrefix(next, prefix+"/") && !strings.HasPrefix(next, "//")
+}
+
+// checkLoginOrigin rejects login submissions from other origins: the Origin
+// header, when sent, must match the host, and the form must echo the CSRF cookie
+func (h *Handler) checkLoginOrigin(c *gin.Context) bool {
+	if origin := c.GetHeader("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || !strings.EqualFold(u.Host, c.Request.Host) {
+			return false
+		}
+	}
+	cookie, err := c.Request.Cookie(csrfCookie)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(c.PostForm("csrf"))) == 1
+}
+
+// csrfToken returns the browser's CSRF token, issuing a new cookie when it has none
+func (h *Handler) csrfToken(c *gin.Context, sessionID string) string {
+	if cookie, err := c.Request.Cookie(csrfCookie); err == nil && len(cookie.Value) == 64 {
+		return cookie.Value
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	token := hex.EncodeToString(b)
+	cookie := &http.Cookie{
+		Name:     csrfCookie,
+		Value:    token,
+		Path:     cookiePath(sessionPrefix(c, sessionID)),
+		HttpOnly: true,
+		Secure:   isSecure(c.Request),
+		SameSite: http.SameSiteStrictMode,
+	}
+	// The mobile app's iframe is cross-site, where a strict cookie is never sent
+	if strings.EqualFold(h.config.LoginCookieSameSite, "none") && cookie.Secure {
+		cookie.SameSite = http.SameSiteNoneMode
+	}
+	http.SetCookie(c.Writer, cookie)
+	return token
+}
+
+// loginCookie builds the login cookie covering the terminal, its WebSocket and attached ports
+func (h *Handler) loginCookie(c *gin.Context, sessionID, value string, expires time.Time) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     loginCookie,
+		Value:    value,
+		Path:     cookiePath(sessionPrefix(c, sessionID)),
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   isSecure(c.Request),
+		SameSite: http.SameSiteLaxMode,
+	}
+	// Cross-site embedding (e.g. the mobile app's iframe) needs SameSite=None,
+	// which browsers only accept on secure cookies
+	if strings.EqualFold(h.config.LoginCookieSameSite, "none") && cookie.Secure {
+		cookie.SameSite = http.SameSiteNoneMode
+	}
+	return cookie
+}
+
+func (h *Handler) renderLogin(c *gin.Context, status int, sessionID, next, message string) {
+	token := h.csrfToken(c, sessionID)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Header("Cache-Control", "no-store")
+	c.Status(status)
+	loginPage.Execute(c.Writer, gin.H{
+		"SessionID": sessionID,
+		"Action":    sessionPrefix(c, sessionID) + "/_login",
+		"Next":      next,
+		"CSRF":      token,
+		"Error":     message,
+	})
+}
+
+func (h *Handler) renderLogout(c *gin.Context, status int, sessionID, message string) {
+	token := h.csrfToken(c, sessionID)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Header("Cache-Control", "no-store")
+	c.Status(status)
+	logoutPage.Execute(c.Writer, gin.H{
+		"SessionID": sessionID,
+		"Action":    sessionPrefix(c, sessionID) + "/_logout",
+		"CSRF":      token,
+		"Error":     message,
+	})
+}
+
+// pageHead is shared by the login and logout pages
+const pageHead = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>clauded - {{.SessionID}}</title>
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, sans-serif; background: #1e1e1e; color: #ddd; display: flex; justify-content: center; align-items: center; min-height: 100vh; margin: 0; }
+form { background: #2a2a2a; padding: 24px; border-radius: 8px; width: 280px; }
+h1 { font-size: 18px; margin: 0 0 16px; }
+label { display: block; font-size: 13px; margin-top: 12px; }
+input { width: 100%; box-sizing: border-box; padding: 10px; margin-top: 4px; border: 1px solid #444; border-radius: 4px; background: #1e1e1e; color: #ddd; font-size: 16px; }
+button { width: 100%; margin-top: 20px; padding: 10px; border: 0; border-radius: 4px; background: #d97757; color: #fff; font-size: 16px; }
+.error { color: #f66; font-size: 13px; margin-top: 12px; }
+</style>
+</head>
+<body>
+`
+
+var loginPage = template.Must(template.New("login").Parse(pageHead + `<form method="post" action="{{.Action}}">
+<h1>Session {{.SessionID}}</h1>
+<input type="hidden" name="next" value="{{.Next}}">
+<input type="hidden" name="csrf" value="{{.CSRF}}">
+<label>Username <input name="username" value="session" autocomplete="username" autocapitalize="none"></label>
+<label>Password <input name="password" type="password" autocomplete="current-password" autofocus></label>
+{{if .Error}}<div class="error">{{.Error}}</div>{{end}}
+<button type="submit">Log in</button>
+</form>
+</body>
+</html>
+`))
+
+var logoutPage = template.Must(template.New("logout").Parse(pageHead + `<form method="post" action="{{.Action}}">
+<h1>Session {{.SessionID}}</h1>
+<input type="hidden" name="csrf" value="{{.CSRF}}">
+{{if .Error}}<div class="error">{{.Error}}</div>{{end}}
+<button type="submit">Log out</button>
+</form>
+</body>
+</html>
+`))
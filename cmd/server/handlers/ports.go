@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"clauded-server/session"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	shareTokenParam = "share_token"   // query parameter of a share link
+	shareCookie     = "clauded_share" // remembers the share token for the port's path
+)
+
+// authorizePort guards traffic to an attached port.
+// Public ports are open. Otherwise the request needs the port's share token
+// (?share_token= on the first visit, then a cookie scoped to the port) or the
+// session's terminal credential, possibly supplied by a login cookie.
+// Credentials accepted here are stripped so they never reach the forwarded service.
+// On failure it writes the error response and returns false.
+func (h *Handler) authorizePort(c *gin.Context, sessionID string, port int) bool {
+	if h.sessionManager.IsPublicPort(sessionID, port) {
+		return true
+	}
+
+	if token := c.Query(shareTokenParam); token != "" && h.sessionManager.VerifyShareToken(sessionID, port, token) {
+		http.SetCookie(c.Writer, &http.Cookie{
+			Name:     shareCookie,
+			Value:    token,
+			Path:     cookiePath(portPrefix(c, sessionID, port)),
+			HttpOnly: true,
+			Secure:   isSecure(c.Request),
+			SameSite: http.SameSiteLaxMode,
+		})
+		query := c.Request.URL.Query()
+		query.Del(shareTokenParam)
+		c.Request.URL.RawQuery = query.Encode()
+		stripCookie(c.Request, shareCookie)
+		return true
+	}
+
+	if cookie, err := c.Request.Cookie(shareCookie); err == nil && h.sessionManager.VerifyShareToken(sessionID, port, cookie.Value) {
+		stripCookie(c.Request, shareCookie)
+		return true
+	}
+
+	if !h.requireCredential(c, sessionID) {
+		return false
+	}
+
+	c.Request.Header.Del("Authorization")
+	return true
+}
+
+// SharePort returns a share link granting access to one attached port
+func (h *Handler) SharePort(c *gin.Context) {
+	sessionID := c.Param("id")
+	port, err := strconv.Atoi(c.Param("port"))
+	if err != nil || port <= 0 || port > 65535 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid port"})
+		return
+	}
+	if !h.authorizeSession(c, sessionID) {
+		return
+	}
+
+	token := h.sessionManager.EnsureShareToken(sessionID, port)
+	log.Printf("Port shared: session=%s, port=%d", sessionID, port)
+
+	c.JSON(http.StatusOK, gin.H{
+		"session_id":  sessionID,
+		"port":        port,
+		"share_token": token,
+		"path":        fmt.Sprintf("%s/?%s=%s", h.publicPortPath(sessionID, port), shareTokenParam, token),
+	})
+}
+
+// RevokePortShare invalidates the share link of an attached port
+func (h *Handler) RevokePortShare(c *gin.Context) {
+	sessionID := c.Param("id")
+	port, err := strconv.Atoi(c.Param("port"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid port"})
+		return
+	}
+	if !h.authorizeSession(c, sessionID) {
+		return
+	}
+
+	if !h.sessionManager.RevokeShareToken(sessionID, port) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "port is not shared"})
+		return
+	}
+
+	log.Printf("Port share revoked: session=%s, port=%d", sessionID, port)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Share link revoked",
+	})
+}
+
+// proxyPort authorizes and forwards a request for an attached port reached under prefix
+func (h *Handler) proxyPort(c *gin.Context, sessionID string, port int, prefix string) {
+	c.Set(portPrefixKey, prefix)
+	if !h.authorizePort(c, sessionID, port) {
+		return
+	}
+	h.sessionManager.Touch(sessionID)
+	endpointID := h.sessionManager.PortEndpointID(sessionID, port)
+	h.proxyManager.ProxyPortRequest(endpointID, prefix)(c.Writer, c.Request)
+}
+
+// ConnectTCP tunnels a WebSocket from clauded connect to a raw TCP port of a
+// session through piko's TCP route, after checking the session credential
+func (h *Handler) ConnectTCP(c *gin.Context) {
+	endpointID := c.Param("endpoint")
+	sessionID, port, _, ok := session.ParseEndpointID(endpointID)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "TCP port not found"})
+		return
+	}
+	if !h.authorizeSession(c, sessionID) {
+		return
+	}
+	if port == 0 || !h.sessionManager.IsTCPPort(sessionID, port) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "TCP port not found"})
+		return
+	}
+
+	c.Request.Header.Del("Authorization")
+	log.Printf("TCP connect: session=%s, port=%d, ip=%s", sessionID, port, c.ClientIP())
+	h.proxyManager.ProxyTCPRequest(session.FormatEndpointID(sessionID, port, ""))(c.Writer, c.Request)
+}
+
+// ListPorts lists the attached ports of a session with their aliases and paths
+func (h *Handler) ListPorts(c *gin.Context) {
+	sessionID := c.Param("id")
+	if !h.authorizeSession(c, sessionID) {
+		return
+	}
+
+	sess, exists := h.sessionManager.Get(sessionID)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+
+	ports := make([]gin.H, 0, len(sess.AttachPorts))
+	for _, port := range sess.AttachPorts {
+		tcp := h.sessionManager.IsTCPPort(sessionID, port)
+		entry := gin.H{
+			"port":   port,
+			"alias":  h.sessionManager.PortAlias(sessionID, port),
+			"public": h.sessionManager.IsPublicPort(sessionID, port),
+			"tcp":    tcp,
+		}
+		// Raw TCP ports have no HTTP path, they are reached with clauded connect
+		if !tcp {
+			entry["path"] = h.publicPortPath(sessionID, port) + "/"
+		}
+		ports = append(ports, entry)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"session_id": sessionID,
+		"ports":      ports,
+	})
+}
+
+// portPrefixKey stores the public path prefix of the attached port being served
+const portPrefixKey = "clauded.port_prefix"
+
+// portPrefix returns the path an attached port lives under for this request,
+// "" on a port subdomain
+func portPrefix(c *gin.Context, sessionID string, port int) string {
+	if prefix, ok := c.Get(portPrefixKey); ok {
+		return prefix.(string)
+	}
+	if _, ok := c.Get(sessionPrefixKey); ok {
+		return ""
+	}
+	return portPath(sessionID, port)
+}
+
+// portPath returns the public path prefix of an attached port
+func portPath(sessionID string, port int) string {
+	return fmt.Sprintf("/%s/%d", sessionID, port)
+}
+
+// publicPortPath returns the preferred path of an attached port, by alias when it has one
+func (h *Handler) publicPortPath(sessionID string, port int) string {
+	if alias := h.sessionManager.PortAlias(sessionID, port); alias != "" {
+		return aliasPath(sessionID, alias)
+	}
+	return portPath(sessionID, port)
+}
+
+// aliasPath returns the public path prefix of an attached port reached by its alias
+func aliasPath(sessionID, alias string) string {
+	return fmt.Sprintf("/%s/_p/%s", sessionID, alias)
+}
+
+// isSecure reports whether the client reached us over HTTPS
+func isSecure(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// stripCookie removes a cookie from the request before it is forwarded
+func stripCookie(r *http.Request, name string) {
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, cookie := range cookies {
+		if cookie.Name != name {
+			r.AddCookie(cookie)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+
+	"clauded-server/session"
+
+	"github.com/gin-gonic/gin"
+)
+
+type RegisterSessionRequest struct {
+	ClientHost  string `json:"client_host"`
+	CodeCmd     string `json:"codecmd"`
+	PublicPorts []int  `json:"public_ports"` // attached ports served without authentication
+	TCPPorts    []int  `json:"tcp_ports"`    // attached ports forwarded as raw TCP
+}
+
+type RegisterSessionResponse struct {
+	session.Session
+	Token string `json:"token"`
+}
+
+// RegisterSession records client details for a session, called by clauded on startup.
+// The client proves ownership with its terminal credential and receives the
+// session token used by the notification API.
+func (h *Handler) RegisterSession(c *gin.Context) {
+	var req RegisterSessionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	sessionID := c.Param("id")
+	if !session.ValidSessionID(sessionID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid session ID: use lowercase letters, digits and underscores"})
+		return
+	}
+	if !h.verifyBasicAuth(c, sessionID, c.GetHeader("Authorization")) {
+		return
+	}
+
+	sess := h.sessionManager.Register(sessionID, req.ClientHost, c.ClientIP(), req.CodeCmd, req.PublicPorts, req.TCPPorts)
+	token := h.sessionManager.EnsureToken(sessionID)
+
+	log.Printf("Session registered: session=%s, host=%s, codecmd=%s", sessionID, req.ClientHost, req.CodeCmd)
+
+	c.JSON(http.StatusOK, RegisterSessionResponse{
+		Session: sess,
+		Token:   token,
+	})
+}
+
+// ListSessions returns all known sessions
+func (h *Handler) ListSessions(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"sessions": h.sessionManager.List(),
+	})
+}
+
+// GetSession returns a single session
+func (h *Handler) GetSession(c *gin.Context) {
+	sess, ok := h.sessionManager.Get(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+	c.JSON(http.StatusOK, sess)
+}
+
+// requireAdmin guards operator routes with ADMIN_TOKEN. Without a configured
+// token the routes stay closed so session IDs are never listed publicly.
+func (h *Handler) requireAdmin(c *gin.Context) {
+	if h.config.AdminToken == "" {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(h.config.AdminToken)) != 1 {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	c.Next()
+}
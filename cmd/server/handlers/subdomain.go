@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"clauded-server/session"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sessionPrefixKey stores the public path prefix of the session being served
+const sessionPrefixKey = "clauded.session_prefix"
+
+// sessionPrefix returns the path the session lives under for this request:
+// "/<session>" with path routing, "" on a session subdomain
+func sessionPrefix(c *gin.Context, sessionID string) string {
+	if prefix, ok := c.Get(sessionPrefixKey); ok {
+		return prefix.(string)
+	}
+	return "/" + sessionID
+}
+
+// cookiePath turns a session prefix into a cookie path
+func cookiePath(prefix string) string {
+	if prefix == "" {
+		return "/"
+	}
+	return prefix
+}
+
+// SubdomainRouter routes <session>.<SESSION_DOMAIN> to the session's terminal and
+// <session>-<port>.<SESSION_DOMAIN> to an attached port, both served at "/".
+// Other hosts fall through to path routing.
+func (h *Handler) SubdomainRouter(c *gin.Context) {
+	label, ok := h.sessionSubdomain(c.Request.Host)
+	if !ok {
+		c.Next()
+		return
+	}
+	c.Abort()
+
+	sessionID, port, _, ok := session.ParseEndpointID(label)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+	c.Set(sessionPrefixKey, "")
+
+	switch c.Request.URL.Path {
+	case "/_login":
+		h.Login(c, sessionID)
+		return
+	case "/_logout":
+		h.Logout(c, sessionID)
+		return
+	}
+
+	loggedIn := h.applyLogin(c, sessionID)
+
+	if port > 0 {
+		if h.sessionManager.IsTCPPort(sessionID, port) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Port is forwarded as raw TCP, use clauded connect"})
+			return
+		}
+		if !h.authorizePort(c, sessionID, port) {
+			return
+		}
+		h.sessionManager.Touch(sessionID)
+		h.proxyManager.ProxyEndpointRequest(h.sessionManager.PortEndpointID(sessionID, port))(c.Writer, c.Request)
+		return
+	}
+
+	if !loggedIn && !h.requireCredential(c, sessionID) {
+		return
+	}
+	h.sessionManager.Touch(sessionID)
+
+	// The terminal itself is served under /<session>
+	c.Request.URL.Path = "/" + sessionID + c.Request.URL.Path
+	c.Request.URL.RawPath = ""
+	h.proxyManager.ProxyEndpointRequest(sessionID)(c.Writer, c.Request)
+}
+
+// sessionSubdomain returns the leftmost label of a host directly under SESSION_DOMAIN
+func (h *Handler) sessionSubdomain(host string) (string, bool) {
+	if h.config.SessionDomain == "" {
+		return "", false
+	}
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+
+	label, ok := strings.CutSuffix(strings.ToLower(host), "."+strings.ToLower(h.config.SessionDomain))
+	if !ok || label == "" || strings.Contains(label, ".") {
+		return "", false
+	}
+	return label, true
+}
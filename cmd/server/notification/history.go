@@ -0,0 +1,214 @@
+package notification
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// History keeps the most recent notifications of every session
+// With a database path, notifications are also written to a BoltDB file
+// (one bucket per session) and reloaded on start
+type History struct {
+	size    int
+	ttl     time.Duration
+	entries map[string][]Notification
+	mu      sync.RWMutex
+	db      *bolt.DB
+}
+
+// NewHistory creates a notification history keeping size notifications per session
+// Sessions quiet for longer than ttl are pruned, 0 keeps them until the session ends
+// dbPath is optional, an empty path keeps the history in memory only
+func NewHistory(size int, ttl time.Duration, dbPath string) (*History, error) {
+	h := &History{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string][]Notification),
+	}
+	if dbPath == "" {
+		return h, nil
+	}
+
+	db, err := bolt.Open(dbPath, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history database: %w", err)
+	}
+	h.db = db
+
+	if err := h.load(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	// No session is live yet, drop what went stale while the server was down
+	h.Prune(nil)
+	return h, nil
+}
+
+// load reads the newest notifications of every session from the database
+func (h *History) load() error {
+	return h.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			var entries []Notification
+			c := b.Cursor()
+			for k, v := c.Last(); k != nil && len(entries) < h.size; k, v = c.Prev() {
+				var notif Notification
+				if err := json.Unmarshal(v, &notif); err != nil {
+					log.Printf("Skipping corrupt history entry for session %s: %v", name, err)
+					continue
+				}
+				entries = append(entries, notif)
+			}
+
+			// Cursor walked newest first
+			for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+				entries[i], entries[j] = entries[j], entries[i]
+			}
+			h.entries[string(name)] = entries
+			return nil
+		})
+	})
+}
+
+// Add records a notification, dropping the oldest one when the session is full
+func (h *History) Add(notif Notification) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	entries := append(h.entries[notif.SessionID], notif)
+	over := len(entries) - h.size
+	if over > 0 {
+		entries = append([]Notification(nil), entries[over:]...)
+	}
+	h.entries[notif.SessionID] = entries
+
+	if h.db != nil {
+		if err := h.persist(notif, over); err != nil {
+			log.Printf("Failed to persist notification %s: %v", notif.ID, err)
+		}
+	}
+}
+
+// persist writes a notification and deletes the oldest over entries
+func (h *History) persist(notif Notification, over int) error {
+	data, err := json.Marshal(notif)
+	if err != nil {
+		return err
+	}
+
+	return h.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(notif.SessionID))
+		if err != nil {
+			return err
+		}
+
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+		if err := b.Put(key, data); err != nil {
+			return err
+		}
+
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil && over > 0; k, _ = c.First() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+			over--
+		}
+		return nil
+	})
+}
+
+// Since returns the session's notifications after the one with ID lastID, oldest first
+// Everything is returned when lastID is empty or no longer in the history
+func (h *History) Since(sessionID, lastID string) []Notification {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	entries := h.entries[sessionID]
+	if lastID != "" {
+		for i, notif := range entries {
+			if notif.ID == lastID {
+				entries = entries[i+1:]
+				break
+			}
+		}
+	}
+
+	result := make([]Notification, len(entries))
+	copy(result, entries)
+	return result
+}
+
+// Remove forgets the history of a session that ended, so a later session
+// reusing its ID does not replay it
+func (h *History) Remove(sessionID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.entries, sessionID)
+	h.deleteBuckets([]string{sessionID})
+}
+
+// Prune removes the history of sessions whose newest notification is older
+// than the TTL, unless keep reports the session as still live
+func (h *History) Prune(keep func(sessionID string) bool) {
+	if h.ttl <= 0 {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	cutoff := time.Now().Add(-h.ttl)
+	var stale []string
+	for sessionID, entries := range h.entries {
+		if keep != nil && keep(sessionID) {
+			continue
+		}
+		if len(entries) == 0 || entries[len(entries)-1].Timestamp.Before(cutoff) {
+			stale = append(stale, sessionID)
+			delete(h.entries, sessionID)
+		}
+	}
+	if len(stale) > 0 {
+		log.Printf("Pruned notification history of %d inactive sessions", len(stale))
+		h.deleteBuckets(stale)
+	}
+}
+
+// deleteBuckets removes the database buckets of sessions
+func (h *History) deleteBuckets(sessionIDs []string) {
+	if h.db == nil {
+		return
+	}
+	err := h.db.Update(func(tx *bolt.Tx) error {
+		for _, sessionID := range sessionIDs {
+			if err := tx.DeleteBucket([]byte(sessionID)); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to delete notification history: %v", err)
+	}
+}
+
+// Close closes the history database
+func (h *History) Close() error {
+	if h.db == nil {
+		return nil
+	}
+	return h.db.Close()
+}
@@ -1,11 +1,11 @@
 package notification
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -16,12 +16,22 @@ import (
 type NotificationType string
 
 const (
-	TaskCompleted NotificationType = "task_completed"
-	Error         NotificationType = "error"
-	Progress      NotificationType = "progress"
-	SystemStatus  NotificationType = "system_status"
+	TaskCompleted   NotificationType = "task_completed"
+	Error           NotificationType = "error"
+	Progress        NotificationType = "progress"
+	SystemStatus    NotificationType = "system_status"
+	WaitingForInput NotificationType = "waiting_for_input" // published by the client, the agent went quiet
+
+	PermissionRequest NotificationType = "permission_request" // published by the client, the agent asks to approve a tool
+	PermissionReply   NotificationType = "permission_reply"   // published by a device, typed into the agent's terminal
 )
 
+// ErrAlreadySubscribed is returned when a webhook URL is already subscribed for a session
+var ErrAlreadySubscribed = errors.New("webhook already subscribed for this session")
+
+// ErrTooManyStreams is returned when a session already has the maximum number of SSE streams
+var ErrTooManyStreams = errors.New("too many notification streams for this session")
+
 // Notification notification message
 type Notification struct {
 	ID        string                 `json:"id"`
@@ -33,30 +43,47 @@ type Notification struct {
 
 // Subscriber notification subscriber
 type Subscriber struct {
-	ID       string
-	SessionID string
-	Channel  chan Notification
-	WebhookURL string
-	EventTypes []NotificationType
+	ID         string             `json:"id"`
+	SessionID  string             `json:"session_id"`
+	Channel    chan Notification  `json:"-"`
+	WebhookURL string             `json:"webhook_url,omitempty"`
+	EventTypes []NotificationType `json:"events"`
+
+	// Webhook delivery state
+	Disabled       bool   `json:"disabled,omitempty"`
+	DisabledReason string `json:"disabled_reason,omitempty"`
+	worker         *webhookWorker
+	secret         string // HMAC key for webhook signatures, only revealed on subscribe
 }
 
 // Service notification service
 type Service struct {
-	subscribers map[string][]*Subscriber
-	mu          sync.RWMutex
-	notifyQueue chan Notification
-	ctx         context.Context
-	cancel      context.CancelFunc
+	subscribers   map[string][]*Subscriber
+	mu            sync.RWMutex
+	notifyQueue   chan Notification
+	ctx           context.Context
+	cancel        context.CancelFunc
+	webhookConfig WebhookConfig
+	httpClient    *http.Client
+	deadLetters   map[string][]DeadLetter
+	deadLettersMu sync.Mutex
+	history       *History
 }
 
 // NewService creates a new notification service
-func NewService() *Service {
+func NewService(webhookConfig WebhookConfig, history *History) *Service {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &Service{
-		subscribers: make(map[string][]*Subscriber),
-		notifyQueue: make(chan Notification, 1000),
-		ctx:         ctx,
-		cancel:      cancel,
+		subscribers:   make(map[string][]*Subscriber),
+		notifyQueue:   make(chan Notification, 1000),
+		ctx:           ctx,
+		cancel:        cancel,
+		webhookConfig: webhookConfig,
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+		deadLetters: make(map[string][]DeadLetter),
+		history:     history,
 	}
 }
 
@@ -91,53 +118,141 @@ func (s *Service) Publish(sessionID string, notifType NotificationType, data map
 }
 
 // SubscribeSSE subscribes to notifications via SSE
-func (s *Service) SubscribeSSE(sessionID string) chan Notification {
+// eventTypes are patterns as understood by MatchEventType, empty means all events.
+// At most maxStreams SSE subscribers are allowed per session (0 means unlimited).
+// The caller must Unsubscribe when the stream ends.
+func (s *Service) SubscribeSSE(sessionID string, eventTypes []NotificationType, maxStreams int) (*Subscriber, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	ch := make(chan Notification, 100)
+	if maxStreams > 0 {
+		streams := 0
+		for _, sub := range s.subscribers[sessionID] {
+			if sub.Channel != nil {
+				streams++
+			}
+		}
+		if streams >= maxStreams {
+			return nil, ErrTooManyStreams
+		}
+	}
+
 	subscriber := &Subscriber{
-		ID:        uuid.New().String(),
-		SessionID: sessionID,
-		Channel:   ch,
-		EventTypes: []NotificationType{TaskCompleted, Error, Progress, SystemStatus},
+		ID:         uuid.New().String(),
+		SessionID:  sessionID,
+		Channel:    make(chan Notification, 100),
+		EventTypes: eventTypes,
 	}
 
 	s.subscribers[sessionID] = append(s.subscribers[sessionID], subscriber)
-	return ch
+	return subscriber, nil
 }
 
 // SubscribeWebhook subscribes to notifications via webhook
-func (s *Service) SubscribeWebhook(sessionID, webhookURL string, eventTypes []NotificationType) error {
+// eventTypes are patterns as understood by MatchEventType, empty means all events.
+// Payloads are signed with secret, a random one is generated when empty.
+// If the URL is already subscribed for the session, the existing
+// subscriber is returned together with ErrAlreadySubscribed.
+// Subscribing again to a disabled webhook re-enables it.
+func (s *Service) SubscribeWebhook(sessionID, webhookURL, secret string, eventTypes []NotificationType) (*Subscriber, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	for _, sub := range s.subscribers[sessionID] {
+		if sub.WebhookURL != webhookURL {
+			continue
+		}
+		if !sub.Disabled {
+			return sub, ErrAlreadySubscribed
+		}
+
+		sub.Disabled = false
+		sub.DisabledReason = ""
+		sub.EventTypes = eventTypes
+		if secret != "" {
+			sub.secret = secret
+		}
+		s.startWebhookWorker(sub)
+		log.Printf("Re-enabled webhook for session %s: %s", sessionID, webhookURL)
+		return sub, nil
+	}
+
+	if secret == "" {
+		secret = newWebhookSecret()
+	}
+
 	subscriber := &Subscriber{
 		ID:         uuid.New().String(),
 		SessionID:  sessionID,
 		WebhookURL: webhookURL,
 		EventTypes: eventTypes,
+		secret:     secret,
 	}
+	s.startWebhookWorker(subscriber)
 
 	s.subscribers[sessionID] = append(s.subscribers[sessionID], subscriber)
 	log.Printf("Subscribed webhook for session %s: %s", sessionID, webhookURL)
-	return nil
+	return subscriber, nil
 }
 
-// Unsubscribe removes a subscriber
-func (s *Service) Unsubscribe(sessionID, subscriberID string) {
+// Unsubscribe removes a subscriber, returns false if it does not exist
+func (s *Service) Unsubscribe(sessionID, subscriberID string) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	subs := s.subscribers[sessionID]
 	for i, sub := range subs {
 		if sub.ID == subscriberID {
-			// Remove subscriber from slice
-			s.subscribers[sessionID] = append(subs[:i], subs[i+1:]...)
-			close(sub.Channel)
-			break
+			s.removeSubscriber(sessionID, i)
+			return true
 		}
 	}
+	return false
+}
+
+// UnsubscribeWebhook removes a webhook subscriber by URL, returns false if it does not exist
+func (s *Service) UnsubscribeWebhook(sessionID, webhookURL string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subs := s.subscribers[sessionID]
+	for i, sub := range subs {
+		if sub.WebhookURL != "" && sub.WebhookURL == webhookURL {
+			s.removeSubscriber(sessionID, i)
+			log.Printf("Unsubscribed webhook for session %s: %s", sessionID, webhookURL)
+			return true
+		}
+	}
+	return false
+}
+
+// Secret returns the webhook signing secret
+func (sub *Subscriber) Secret() string {
+	return sub.secret
+}
+
+// removeSubscriber must be called with s.mu held
+func (s *Service) removeSubscriber(sessionID string, index int) {
+	subs := s.subscribers[sessionID]
+	sub := subs[index]
+
+	// Build a new slice so copies handed out by GetSubscribers stay intact
+	remaining := make([]*Subscriber, 0, len(subs)-1)
+	remaining = append(remaining, subs[:index]...)
+	remaining = append(remaining, subs[index+1:]...)
+	if len(remaining) == 0 {
+		delete(s.subscribers, sessionID)
+	} else {
+		s.subscribers[sessionID] = remaining
+	}
+
+	if sub.Channel != nil {
+		close(sub.Channel)
+	}
+	if sub.worker != nil {
+		sub.worker.stop()
+		sub.worker = nil
+	}
 }
 
 // processNotifications processes notifications from the queue
@@ -155,8 +270,10 @@ func (s *Service) processNotifications() {
 	}
 }
 
-// distributeNotification distributes notification to all subscribers
+// distributeNotification records the notification and distributes it to all subscribers
 func (s *Service) distributeNotification(notif Notification) {
+	s.history.Add(notif)
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -167,7 +284,7 @@ func (s *Service) distributeNotification(notif Notification) {
 
 	for _, sub := range subs {
 		// Check if subscriber is interested in this event type
-		if !s.isEventTypeMatch(notif.Type, sub.EventTypes) {
+		if !MatchEventType(notif.Type, sub.EventTypes) {
 			continue
 		}
 
@@ -180,45 +297,46 @@ func (s *Service) distributeNotification(notif Notification) {
 			}
 		}
 
-		// Send to webhook subscribers
-		if sub.WebhookURL != "" {
-			go s.sendWebhook(sub.WebhookURL, notif)
+		// Queue for webhook delivery
+		if sub.worker != nil {
+			sub.worker.enqueue(notif)
 		}
 	}
 }
 
-// isEventTypeMatch checks if event type matches subscriber's interests
-func (s *Service) isEventTypeMatch(eventType NotificationType, eventTypes []NotificationType) bool {
-	for _, et := range eventTypes {
-		if et == eventType {
+// MatchEventType checks if an event type matches a subscriber's patterns
+// An empty list or "*" matches everything, a trailing "*" matches by prefix
+// ("task.*" matches "task.completed")
+func MatchEventType(eventType NotificationType, patterns []NotificationType) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if prefix, ok := strings.CutSuffix(string(p), "*"); ok {
+			if strings.HasPrefix(string(eventType), prefix) {
+				return true
+			}
+		} else if p == eventType {
 			return true
 		}
 	}
 	return false
 }
 
-// sendWebhook sends notification to webhook URL
-func (s *Service) sendWebhook(url string, notif Notification) {
-	client := &http.Client{
-		Timeout: 10 * time.Second,
-	}
-
-	data, err := json.Marshal(notif)
-	if err != nil {
-		log.Printf("Failed to marshal notification: %v", err)
-		return
-	}
+// History returns the session's notifications published after lastID, oldest first
+// An empty or unknown lastID returns the whole history
+func (s *Service) History(sessionID, lastID string) []Notification {
+	return s.history.Since(sessionID, lastID)
+}
 
-	resp, err := client.Post(url, "application/json", bytes.NewReader(data))
-	if err != nil {
-		log.Printf("Failed to send webhook to %s: %v", url, err)
-		return
-	}
-	defer resp.Body.Close()
+// ForgetSession drops the history of a session that ended
+func (s *Service) ForgetSession(sessionID string) {
+	s.history.Remove(sessionID)
+}
 
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("Webhook returned non-OK status: %d", resp.StatusCode)
-	}
+// PruneHistory drops the history of inactive sessions, keep protects live ones
+func (s *Service) PruneHistory(keep func(sessionID string) bool) {
+	s.history.Prune(keep)
 }
 
 // GetSubscribers returns all subscribers for a session
@@ -231,8 +349,12 @@ func (s *Service) GetSubscribers(sessionID string) []*Subscriber {
 		return []*Subscriber{}
 	}
 
-	// Return a copy to avoid race conditions
+	// Return copies to avoid race conditions with delivery workers
 	result := make([]*Subscriber, len(subs))
-	copy(result, subs)
+	for i, sub := range subs {
+		c := *sub
+		c.worker = nil
+		result[i] = &c
+	}
 	return result
 }
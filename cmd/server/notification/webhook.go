@@ -0,0 +1,255 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Webhook request headers
+const (
+	SignatureHeader = "X-Clauded-Signature" // "sha256=" + hex HMAC of "<timestamp>.<body>"
+	TimestampHeader = "X-Clauded-Timestamp" // unix seconds, fresh for every attempt
+	DeliveryHeader  = "X-Clauded-Delivery"  // notification ID, stable across retries
+	EventHeader     = "X-Clauded-Event"     // notification type
+)
+
+// WebhookConfig webhook delivery configuration
+type WebhookConfig struct {
+	MaxAttempts    int           // delivery attempts before a notification is dead-lettered
+	QueueSize      int           // pending notifications per subscriber
+	InitialBackoff time.Duration // first retry delay, doubled on every attempt
+	MaxBackoff     time.Duration // retry delay cap
+	DisableAfter   time.Duration // disable a subscriber failing continuously for this long, 0 never disables
+	DeadLetterSize int           // dead letters kept per session
+}
+
+// DefaultWebhookConfig returns the default webhook delivery configuration
+func DefaultWebhookConfig() WebhookConfig {
+	return WebhookConfig{
+		MaxAttempts:    8,
+		QueueSize:      100,
+		InitialBackoff: time.Second,
+		MaxBackoff:     5 * time.Minute,
+		DisableAfter:   24 * time.Hour,
+		DeadLetterSize: 100,
+	}
+}
+
+// DeadLetter a webhook notification that could not be delivered
+type DeadLetter struct {
+	SubscriptionID string       `json:"subscription_id"`
+	WebhookURL     string       `json:"webhook_url"`
+	Notification   Notification `json:"notification"`
+	Attempts       int          `json:"attempts"`
+	LastError      string       `json:"last_error"`
+	FailedAt       time.Time    `json:"failed_at"`
+}
+
+// webhookWorker delivers notifications to one webhook subscriber in order
+type webhookWorker struct {
+	service      *Service
+	sub          *Subscriber
+	queue        chan Notification
+	ctx          context.Context
+	cancel       context.CancelFunc
+	failingSince time.Time
+}
+
+// startWebhookWorker starts the delivery worker for a webhook subscriber
+func (s *Service) startWebhookWorker(sub *Subscriber) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	w := &webhookWorker{
+		service: s,
+		sub:     sub,
+		queue:   make(chan Notification, s.webhookConfig.QueueSize),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	sub.worker = w
+	go w.run()
+}
+
+// enqueue queues a notification without blocking
+func (w *webhookWorker) enqueue(notif Notification) {
+	select {
+	case w.queue <- notif:
+	default:
+		w.service.addDeadLetter(w.sub, notif, 0, "delivery queue full")
+	}
+}
+
+// stop stops the worker, pending notifications are dropped
+func (w *webhookWorker) stop() {
+	w.cancel()
+}
+
+func (w *webhookWorker) run() {
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+		case notif := <-w.queue:
+			if !w.deliver(notif) {
+				return
+			}
+		}
+	}
+}
+
+// deliver retries a notification with exponential backoff
+// Returns false when the subscriber was disabled or the worker stopped
+func (w *webhookWorker) deliver(notif Notification) bool {
+	cfg := w.service.webhookConfig
+	backoff := cfg.InitialBackoff
+
+	for attempt := 1; ; attempt++ {
+		err := w.service.sendWebhook(w.ctx, w.sub, notif)
+		if err == nil {
+			w.failingSince = time.Time{}
+			return true
+		}
+
+		if w.ctx.Err() != nil {
+			return false
+		}
+
+		if w.failingSince.IsZero() {
+			w.failingSince = time.Now()
+		}
+		log.Printf("Webhook delivery to %s failed (attempt %d/%d): %v", w.sub.WebhookURL, attempt, cfg.MaxAttempts, err)
+
+		if cfg.DisableAfter > 0 && time.Since(w.failingSince) >= cfg.DisableAfter {
+			w.service.addDeadLetter(w.sub, notif, attempt, err.Error())
+			w.disable(err)
+			return false
+		}
+
+		if attempt >= cfg.MaxAttempts {
+			w.service.addDeadLetter(w.sub, notif, attempt, err.Error())
+			return true
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-w.ctx.Done():
+			return false
+		}
+
+		backoff *= 2
+		if backoff > cfg.MaxBackoff {
+			backoff = cfg.MaxBackoff
+		}
+	}
+}
+
+// disable marks the subscriber disabled and dead-letters everything still queued
+func (w *webhookWorker) disable(lastErr error) {
+	reason := fmt.Sprintf("failing since %s: %v", w.failingSince.Format(time.RFC3339), lastErr)
+
+	w.service.mu.Lock()
+	w.sub.Disabled = true
+	w.sub.DisabledReason = reason
+	w.sub.worker = nil
+	w.service.mu.Unlock()
+
+	log.Printf("Webhook disabled for session %s: %s (%s)", w.sub.SessionID, w.sub.WebhookURL, reason)
+
+	for {
+		select {
+		case notif := <-w.queue:
+			w.service.addDeadLetter(w.sub, notif, 0, "webhook disabled")
+		default:
+			w.cancel()
+			return
+		}
+	}
+}
+
+// sendWebhook sends a signed notification to the subscriber's webhook URL
+// Any network error or non-2xx response is a failed delivery
+func (s *Service) sendWebhook(ctx context.Context, sub *Subscriber, notif Notification) error {
+	data, err := json.Marshal(notif)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.WebhookURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(DeliveryHeader, notif.ID)
+	req.Header.Set(EventHeader, string(notif.Type))
+
+	timestamp := time.Now().Unix()
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(sub.secret, timestamp, data))
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Sign computes the webhook signature header value for a request body
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// newWebhookSecret generates a random signing secret
+func newWebhookSecret() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return "whsec_" + hex.EncodeToString(b)
+}
+
+// addDeadLetter records an undeliverable notification, keeping the newest entries
+func (s *Service) addDeadLetter(sub *Subscriber, notif Notification, attempts int, lastErr string) {
+	s.deadLettersMu.Lock()
+	defer s.deadLettersMu.Unlock()
+
+	letters := append(s.deadLetters[sub.SessionID], DeadLetter{
+		SubscriptionID: sub.ID,
+		WebhookURL:     sub.WebhookURL,
+		Notification:   notif,
+		Attempts:       attempts,
+		LastError:      lastErr,
+		FailedAt:       time.Now(),
+	})
+	if over := len(letters) - s.webhookConfig.DeadLetterSize; over > 0 {
+		letters = letters[over:]
+	}
+	s.deadLetters[sub.SessionID] = letters
+}
+
+// GetDeadLetters returns the dead letters for a session, oldest first
+func (s *Service) GetDeadLetters(sessionID string) []DeadLetter {
+	s.deadLettersMu.Lock()
+	defer s.deadLettersMu.Unlock()
+
+	result := make([]DeadLetter, len(s.deadLetters[sessionID]))
+	copy(result, s.deadLetters[sessionID])
+	return result
+}
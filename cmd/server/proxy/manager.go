@@ -1,31 +1,71 @@
 package proxy
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"path"
 	"strings"
 	"time"
+
+	"github.com/golang-jwt/jwt/v5"
 )
 
+// ErrSessionNotConnected is returned when no client serves the session
+var ErrSessionNotConnected = errors.New("session not connected")
+
 // Manager manages the proxy connections to piko
 type Manager struct {
-	pikoProxyURL    string
-	pikoUpstreamURL string
-	proxyPort       int
-	upstreamPort    int
+	pikoProxyURL      string
+	pikoUpstreamURL   string
+	proxyPort         int
+	upstreamPort      int
+	upstreamTransport http.RoundTripper
+	httpClient        *http.Client
+	rewriteBody       bool   // rewrite root-relative URLs in attached-port HTML/CSS
+	proxySecret       []byte // signs the tokens piko's proxy port requires
 }
 
 // NewManager creates a new proxy manager
-func NewManager(proxyPort, upstreamPort int) *Manager {
-	return &Manager{
+// upstreamTLS must be set when the piko upstream port itself serves TLS
+func NewManager(proxyPort, upstreamPort int, upstreamTLS bool) *Manager {
+	m := &Manager{
 		proxyPort:       proxyPort,
 		upstreamPort:    upstreamPort,
 		pikoProxyURL:    fmt.Sprintf("http://127.0.0.1:%d", proxyPort),
 		pikoUpstreamURL: fmt.Sprintf("http://127.0.0.1:%d", upstreamPort),
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+		proxySecret: make([]byte, 32),
+	}
+	if _, err := rand.Read(m.proxySecret); err != nil {
+		panic(err)
 	}
+
+	if upstreamTLS {
+		// The upstream certificate is issued for the public host name,
+		// not for the loopback address we dial
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		m.upstreamTransport = transport
+		m.pikoUpstreamURL = fmt.Sprintf("https://127.0.0.1:%d", upstreamPort)
+	}
+
+	return m
+}
+
+// SetRewriteBody enables rewriting root-relative URLs in HTML and CSS served by attached ports
+func (m *Manager) SetRewriteBody(enabled bool) {
+	m.rewriteBody = enabled
 }
 
 // ProxyRequest creates a handler that proxies requests to piko
@@ -43,6 +83,11 @@ func (m *Manager) ProxyRequest() http.HandlerFunc {
 		}
 
 		sessionID := parts[0]
+
+		if reservedPikoPath(r.URL.Path) {
+			http.NotFound(w, r)
+			return
+		}
 		
 		// Create proxy director
 		targetURL, _ := url.Parse(m.pikoProxyURL)
@@ -55,6 +100,7 @@ func (m *Manager) ProxyRequest() http.HandlerFunc {
 
 				// Set piko endpoint header
 				pr.Out.Header.Set("X-Piko-Endpoint", sessionID)
+				m.authorizeProxy(pr.Out.Header, sessionID)
 				log.Printf("DEBUG: Setting X-Piko-Endpoint header: %s", sessionID)
 
 				// Copy other headers
@@ -91,6 +137,67 @@ func (m *Manager) ProxyRequest() http.HandlerFunc {
 	}
 }
 
+// ProxyEndpointRequest creates a handler that proxies requests to a piko
+// endpoint with the path unchanged. This is used for subdomain routing.
+func (m *Manager) ProxyEndpointRequest(endpointID string) http.HandlerFunc {
+	serve := m.proxyEndpoint(endpointID)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if reservedPikoPath(r.URL.Path) {
+			http.NotFound(w, r)
+			return
+		}
+		serve(w, r)
+	}
+}
+
+// ProxyTCPRequest creates a handler that hands a WebSocket to piko's raw TCP
+// route (/_piko/v1/tcp/:endpoint). Only ConnectTCP, after authorizing the
+// session, may reach that reserved path.
+func (m *Manager) ProxyTCPRequest(endpointID string) http.HandlerFunc {
+	return m.proxyEndpoint(endpointID)
+}
+
+// proxyEndpoint proxies requests to a piko endpoint with the path unchanged
+func (m *Manager) proxyEndpoint(endpointID string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		targetURL, _ := url.Parse(m.pikoProxyURL)
+		proxy := &httputil.ReverseProxy{
+			Rewrite: func(pr *httputil.ProxyRequest) {
+				pr.Out.URL = targetURL
+				pr.Out.URL.Path = r.URL.Path
+				pr.Out.URL.RawQuery = r.URL.RawQuery
+
+				pr.Out.Header.Set("X-Piko-Endpoint", endpointID)
+				m.authorizeProxy(pr.Out.Header, endpointID)
+				pr.Out.Header.Set("X-Forwarded-Host", r.Host)
+				pr.Out.Header.Set("X-Forwarded-Proto", scheme(r))
+
+				// Handle WebSocket upgrade
+				if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+					pr.Out.Header.Set("Upgrade", "websocket")
+					pr.Out.Header.Set("Connection", "Upgrade")
+				}
+			},
+			ModifyResponse: func(resp *http.Response) error {
+				// Upstream not connected, see ProxyRequest
+				if resp.StatusCode == http.StatusBadGateway {
+					resp.StatusCode = http.StatusNotFound
+				}
+				return nil
+			},
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				log.Printf("Proxy error for endpoint %s: %v", endpointID, err)
+				http.Error(w, "Proxy error", http.StatusBadGateway)
+			},
+		}
+
+		// Flush the response after writing to support SSE/WebSocket
+		proxy.FlushInterval = 100 * time.Millisecond
+
+		proxy.ServeHTTP(w, r)
+	}
+}
+
 // ProxyRootRequest creates a handler that proxies requests to piko as root-service
 // This is used for "/" and "/piko" paths
 func (m *Manager) ProxyRootRequest() http.HandlerFunc {
@@ -106,6 +213,7 @@ func (m *Manager) ProxyRootRequest() http.HandlerFunc {
 
 				// Set piko endpoint header to root-service
 				pr.Out.Header.Set("X-Piko-Endpoint", "root-service")
+				m.authorizeProxy(pr.Out.Header, "root-service")
 
 				// Copy other headers
 				pr.Out.Header.Set("X-Forwarded-Host", r.Host)
@@ -183,6 +291,8 @@ func (m *Manager) ProxyUpstreamRequest() http.HandlerFunc {
 			},
 		}
 
+		proxy.Transport = m.upstreamTransport
+
 		// Flush the response after writing to support SSE/WebSocket
 		proxy.FlushInterval = 100 * time.Millisecond
 
@@ -191,54 +301,49 @@ func (m *Manager) ProxyUpstreamRequest() http.HandlerFunc {
 	}
 }
 
-// ProxyPortRequest creates a handler that proxies requests for attached ports
-// This handles /:session/:port paths where port is a forwarded port
-func (m *Manager) ProxyPortRequest() http.HandlerFunc {
+// ProxyPortRequest creates a handler that proxies requests for an attached port
+// reached under prefix (e.g. /:session/:port or /:session/_p/:alias) to its piko endpoint
+func (m *Manager) ProxyPortRequest(endpointID, prefix string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		log.Printf("DEBUG: ProxyPortRequest hit. URL: %s", r.URL.Path)
 
-		// Extract session ID and port from URL path
-		path := strings.TrimPrefix(r.URL.Path, "/")
-		parts := strings.Split(path, "/")
-
-		if len(parts) < 2 {
-			http.Error(w, "Invalid request format", http.StatusBadRequest)
+		// The path the app sees, without the port prefix
+		outPath := "/" + strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, prefix), "/")
+		if reservedPikoPath(outPath) {
+			http.NotFound(w, r)
 			return
 		}
 
-		sessionID := parts[0]
-		port := parts[1]
-
-		// Validate port is numeric
-		if _, err := fmt.Sscanf(port, "%d", new(int)); err != nil {
-			http.Error(w, "Invalid port number", http.StatusBadRequest)
-			return
+		// The app is served at "/", its links and redirects need the public prefix
+		rewriter := &prefixRewriter{
+			prefix: prefix,
+			host:   r.Host,
 		}
 
-		// Create endpoint ID: {sessionID}-{port}
-		endpointID := fmt.Sprintf("%s-%s", sessionID, port)
-
 		// Create proxy director
 		targetURL, _ := url.Parse(m.pikoProxyURL)
 		proxy := &httputil.ReverseProxy{
 			Rewrite: func(pr *httputil.ProxyRequest) {
 				// Set the target URL
 				pr.Out.URL = targetURL
-				// Keep original path (strip /session/port prefix)
-				if len(parts) > 2 {
-					pr.Out.URL.Path = "/" + strings.Join(parts[2:], "/")
-				} else {
-					pr.Out.URL.Path = "/"
-				}
+				// Keep original path (strip the port prefix)
+				pr.Out.URL.Path = outPath
 				pr.Out.URL.RawQuery = r.URL.RawQuery
 
 				// Set piko endpoint header
 				pr.Out.Header.Set("X-Piko-Endpoint", endpointID)
+				m.authorizeProxy(pr.Out.Header, endpointID)
 				log.Printf("DEBUG: Setting X-Piko-Endpoint header: %s", endpointID)
 
 				// Copy other headers
 				pr.Out.Header.Set("X-Forwarded-Host", r.Host)
 				pr.Out.Header.Set("X-Forwarded-Proto", scheme(r))
+				pr.Out.Header.Set("X-Forwarded-Prefix", rewriter.prefix)
+
+				// Bodies can only be rewritten uncompressed
+				if m.rewriteBody {
+					pr.Out.Header.Del("Accept-Encoding")
+				}
 
 				// Handle WebSocket upgrade
 				if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
@@ -251,11 +356,12 @@ func (m *Manager) ProxyPortRequest() http.HandlerFunc {
 				// We map this to 404 to indicate "Session Not Found".
 				if resp.StatusCode == http.StatusBadGateway {
 					resp.StatusCode = http.StatusNotFound
+					return nil
 				}
-				return nil
+				return rewriter.rewriteResponse(resp, m.rewriteBody)
 			},
 			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
-				log.Printf("Proxy error for session %s port %s: %v", sessionID, port, err)
+				log.Printf("Proxy error for endpoint %s: %v", endpointID, err)
 				http.Error(w, "Proxy error", http.StatusBadGateway)
 			},
 		}
@@ -269,6 +375,68 @@ func (m *Manager) ProxyPortRequest() http.HandlerFunc {
 }
 
 
+// ProxySecret returns the HMAC key piko's proxy port verifies request tokens with.
+// It is random per process, only this server ever signs proxy tokens.
+func (m *Manager) ProxySecret() string {
+	return hex.EncodeToString(m.proxySecret)
+}
+
+// authorizeProxy adds a short-lived token limited to endpointID, so a request
+// can only reach the endpoint the server routed it to
+func (m *Manager) authorizeProxy(header http.Header, endpointID string) {
+	claims := jwt.MapClaims{
+		"exp": time.Now().Add(time.Minute).Unix(),
+		"piko": map[string]interface{}{
+			"endpoints": []string{endpointID},
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(m.ProxySecret()))
+	if err != nil {
+		log.Printf("Failed to sign piko proxy token: %v", err)
+		return
+	}
+	header.Set("X-Piko-Authorization", "Bearer "+token)
+}
+
+// reservedPikoPath reports whether a path reaches piko's own routes, such as the
+// raw TCP tunnel at /_piko/v1/tcp, which piko serves before looking at X-Piko-Endpoint
+func reservedPikoPath(p string) bool {
+	cleaned := path.Clean("/" + p)
+	return cleaned == "/_piko" || strings.HasPrefix(cleaned, "/_piko/")
+}
+
+// VerifyCredential checks an Authorization header against the session's
+// terminal basic auth by sending it through piko to the client's gotty
+func (m *Manager) VerifyCredential(ctx context.Context, sessionID, authorization string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/%s/", m.pikoProxyURL, sessionID), nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("X-Piko-Endpoint", sessionID)
+	m.authorizeProxy(req.Header, sessionID)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	resp, err := m.httpClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to reach piko proxy: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return false, nil
+	case resp.StatusCode == http.StatusBadGateway:
+		return false, ErrSessionNotConnected
+	case resp.StatusCode >= 200 && resp.StatusCode < 400:
+		return true, nil
+	default:
+		return false, fmt.Errorf("unexpected status %d from session", resp.StatusCode)
+	}
+}
+
 // scheme returns the scheme of the request (http or https)
 func scheme(r *http.Request) string {
 	if r.TLS != nil {
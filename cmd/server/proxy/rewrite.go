@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxRewriteBody is the largest HTML/CSS body rewritten, larger bodies pass through
+const maxRewriteBody = 10 << 20
+
+// Root-relative URLs, each match ends with the leading "/" of the URL
+var (
+	htmlAttrPattern = regexp.MustCompile(`(?i)\s(?:href|src|action|poster|formaction)\s*=\s*["']?/`)
+	cssURLPattern   = regexp.MustCompile(`(?i)url\(\s*["']?/`)
+)
+
+// prefixRewriter maps an app served at "/" to the public prefix it is reached under
+type prefixRewriter struct {
+	prefix string // e.g. "/abc12/3000", no trailing slash
+	host   string // public host, absolute URLs to it are rewritten too
+}
+
+// rewriteResponse fixes redirects, cookie paths and optionally HTML/CSS links
+func (pr *prefixRewriter) rewriteResponse(resp *http.Response, rewriteBody bool) error {
+	if location := resp.Header.Get("Location"); location != "" {
+		resp.Header.Set("Location", pr.location(location))
+	}
+
+	if cookies := resp.Header.Values("Set-Cookie"); len(cookies) > 0 {
+		resp.Header.Del("Set-Cookie")
+		for _, cookie := range cookies {
+			resp.Header.Add("Set-Cookie", pr.setCookie(cookie))
+		}
+	}
+
+	if rewriteBody {
+		return pr.body(resp)
+	}
+	return nil
+}
+
+// location prefixes root-relative redirects and absolute ones to the public host
+func (pr *prefixRewriter) location(location string) string {
+	u, err := url.Parse(location)
+	if err != nil {
+		return location
+	}
+	if u.Host != "" && !strings.EqualFold(u.Host, pr.host) {
+		return location
+	}
+	if !strings.HasPrefix(u.Path, "/") || pr.hasPrefix(u.Path) {
+		return location
+	}
+	u.Path = pr.prefix + u.Path
+	u.RawPath = ""
+	return u.String()
+}
+
+// setCookie scopes a cookie's Path attribute to the prefix
+func (pr *prefixRewriter) setCookie(cookie string) string {
+	attrs := strings.Split(cookie, ";")
+	for i, attr := range attrs {
+		name, value, ok := strings.Cut(strings.TrimSpace(attr), "=")
+		if !ok || !strings.EqualFold(name, "path") || !strings.HasPrefix(value, "/") || pr.hasPrefix(value) {
+			continue
+		}
+		attrs[i] = " Path=" + pr.prefix + value
+	}
+	return strings.Join(attrs, ";")
+}
+
+// body rewrites root-relative URLs in uncompressed HTML and CSS
+func (pr *prefixRewriter) body(resp *http.Response) error {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "text/css" {
+		return nil
+	}
+	if resp.Header.Get("Content-Encoding") != "" || resp.ContentLength > maxRewriteBody {
+		return nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxRewriteBody+1))
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+	if len(data) > maxRewriteBody {
+		// Too large to rewrite, stream it through as is
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(data), resp.Body), resp.Body}
+		return nil
+	}
+	resp.Body.Close()
+
+	if mediaType == "text/html" {
+		data = pr.rewriteURLs(data, htmlAttrPattern)
+	}
+	data = pr.rewriteURLs(data, cssURLPattern)
+
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	resp.ContentLength = int64(len(data))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(data)))
+	return nil
+}
+
+// rewriteURLs prefixes the URLs matched by pattern, skipping protocol-relative
+// URLs ("//host") and ones already under the prefix
+func (pr *prefixRewriter) rewriteURLs(data []byte, pattern *regexp.Regexp) []byte {
+	var out bytes.Buffer
+	last := 0
+	for _, m := range pattern.FindAllIndex(data, -1) {
+		slash := m[1] - 1
+		rest := data[slash:]
+		if bytes.HasPrefix(rest, []byte("//")) || bytes.HasPrefix(rest, []byte(pr.prefix+"/")) {
+			continue
+		}
+		out.Write(data[last:slash])
+		out.WriteString(pr.prefix)
+		last = slash
+	}
+	if last == 0 {
+		return data
+	}
+	out.Write(data[last:])
+	return out.Bytes()
+}
+
+// hasPrefix reports whether a path is already under the prefix
+func (pr *prefixRewriter) hasPrefix(path string) bool {
+	return path == pr.prefix || strings.HasPrefix(path, pr.prefix+"/")
+}
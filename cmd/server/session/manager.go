@@ -1,22 +1,45 @@
 package session
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
-
-	"github.com/google/uuid"
 )
 
+// RootEndpointID is the piko endpoint serving "/", it is not a session
+const RootEndpointID = "root-service"
+
 // Session session information
 type Session struct {
-	ID        string
-	CreatedAt time.Time
-	LastSeen  time.Time
-	Metadata  map[string]interface{}
-	mu        sync.RWMutex
+	ID          string                 `json:"id"`
+	ClientHost  string                 `json:"client_host"`
+	ClientIP    string                 `json:"client_ip"`
+	CodeCmd     string                 `json:"codecmd"`
+	AttachPorts []int                  `json:"attach_ports"`
+	PublicPorts []int                  `json:"public_ports"` // attached ports served without authentication
+	PortAliases map[string]int         `json:"port_aliases"` // alias -> attached port, reachable at /<session>/_p/<alias>/
+	TCPPorts    []int                  `json:"tcp_ports"`    // attached ports forwarded as raw TCP, reachable with clauded connect
+	Connected   bool                   `json:"connected"`
+	ConnectedAt time.Time              `json:"connected_since"`
+	CreatedAt   time.Time              `json:"created_at"`
+	LastSeen    time.Time              `json:"last_activity"`
+	Metadata    map[string]interface{} `json:"metadata"`
+	Token       string                 `json:"-"` // proves session ownership to the notification API
+	ShareTokens map[int]string         `json:"-"` // attached port -> share token
+
+	registered bool // registered since the client last connected
 }
 
 // Manager session manager
+// Sessions are returned as copies, so callers never share state with the manager
 type Manager struct {
 	sessions map[string]*Session
 	mu       sync.RWMutex
@@ -29,38 +52,213 @@ func NewManager() *Manager {
 	}
 }
 
-// Create creates a new session
-func (m *Manager) Create() *Session {
+// Create creates a session with the given ID, or returns the existing one
+func (m *Manager) Create(id string) Session {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	return m.getOrCreate(id).copy()
+}
+
+// getOrCreate must be called with m.mu held
+func (m *Manager) getOrCreate(id string) *Session {
+	if session, exists := m.sessions[id]; exists {
+		return session
+	}
+
+	now := time.Now()
 	session := &Session{
-		ID:        uuid.New().String(),
-		CreatedAt: time.Now(),
-		LastSeen:  time.Now(),
+		ID:        id,
+		CreatedAt: now,
+		LastSeen:  now,
 		Metadata:  make(map[string]interface{}),
 	}
-
-	m.sessions[session.ID] = session
+	m.sessions[id] = session
 	return session
 }
 
 // Get gets a session by ID
-func (m *Manager) Get(id string) (*Session, bool) {
+func (m *Manager) Get(id string) (Session, bool) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
 	session, exists := m.sessions[id]
 	if !exists {
-		return nil, false
+		return Session{}, false
+	}
+	return session.copy(), true
+}
+
+// List returns all sessions sorted by ID
+func (m *Manager) List() []Session {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	result := make([]Session, 0, len(m.sessions))
+	for _, session := range m.sessions {
+		result = append(result, session.copy())
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+	return result
+}
+
+// Touch records activity on a session
+func (m *Manager) Touch(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if session, exists := m.sessions[id]; exists {
+		session.LastSeen = time.Now()
 	}
+}
+
+// Register records the client-reported details of a session.
+// The first registration after a (re)connect mints a fresh token and drops
+// share tokens, so nothing issued to a previous client carries over.
+func (m *Manager) Register(id, clientHost, clientIP, codeCmd string, publicPorts, tcpPorts []int) Session {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	// Update last seen
-	session.mu.Lock()
+	session := m.getOrCreate(id)
+	if !session.registered {
+		session.Token = newToken()
+		session.ShareTokens = nil
+		session.registered = true
+	}
+	session.ClientHost = clientHost
+	session.ClientIP = clientIP
+	session.CodeCmd = codeCmd
+	session.PublicPorts = append([]int(nil), publicPorts...)
+	sort.Ints(session.PublicPorts)
+	session.TCPPorts = append([]int(nil), tcpPorts...)
+	sort.Ints(session.TCPPorts)
 	session.LastSeen = time.Now()
-	session.mu.Unlock()
+	return session.copy()
+}
+
+// IsAttachedPort reports whether the session's client currently serves a port
+func (m *Manager) IsAttachedPort(id string, port int) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	session, exists := m.sessions[id]
+	if !exists {
+		return false
+	}
+	for _, p := range session.AttachPorts {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
+
+// IsTCPPort reports whether an attached port was registered as raw TCP
+func (m *Manager) IsTCPPort(id string, port int) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	session, exists := m.sessions[id]
+	if !exists {
+		return false
+	}
+	for _, p := range session.TCPPorts {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
+
+// IsPublicPort reports whether an attached port was registered as public
+func (m *Manager) IsPublicPort(id string, port int) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	session, exists := m.sessions[id]
+	if !exists {
+		return false
+	}
+	for _, p := range session.PublicPorts {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
 
-	return session, true
+// EnsureShareToken returns the share token of an attached port, minting one if needed
+func (m *Manager) EnsureShareToken(id string, port int) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session := m.getOrCreate(id)
+	if session.ShareTokens == nil {
+		session.ShareTokens = make(map[int]string)
+	}
+	if session.ShareTokens[port] == "" {
+		session.ShareTokens[port] = newToken()
+	}
+	return session.ShareTokens[port]
+}
+
+// RevokeShareToken removes the share token of an attached port
+func (m *Manager) RevokeShareToken(id string, port int) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, exists := m.sessions[id]
+	if !exists || session.ShareTokens[port] == "" {
+		return false
+	}
+	delete(session.ShareTokens, port)
+	return true
+}
+
+// VerifyShareToken checks a token against the share token of an attached port
+func (m *Manager) VerifyShareToken(id string, port int, token string) bool {
+	if token == "" {
+		return false
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	session, exists := m.sessions[id]
+	if !exists || session.ShareTokens[port] == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(session.ShareTokens[port]), []byte(token)) == 1
+}
+
+// EnsureToken returns the session's token, minting one if needed
+func (m *Manager) EnsureToken(id string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session := m.getOrCreate(id)
+	if session.Token == "" {
+		session.Token = newToken()
+	}
+	return session.Token
+}
+
+// VerifyToken checks a token against the session's token
+func (m *Manager) VerifyToken(id, token string) bool {
+	if token == "" {
+		return false
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	session, exists := m.sessions[id]
+	if !exists || session.Token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(session.Token), []byte(token)) == 1
 }
 
 // Delete deletes a session
@@ -81,26 +279,186 @@ func (m *Manager) UpdateMetadata(id string, metadata map[string]interface{}) boo
 		return false
 	}
 
-	session.mu.Lock()
-	defer session.mu.Unlock()
-
 	for k, v := range metadata {
 		session.Metadata[k] = v
 	}
 	return true
 }
 
-// Cleanup cleans up expired sessions
-func (m *Manager) Cleanup(timeout time.Duration) {
+// SyncEndpoints updates connection state from the piko endpoint list
+// (endpoint ID -> number of upstream connections)
+func (m *Manager) SyncEndpoints(endpoints map[string]int) {
+	type liveSession struct {
+		ports   []int
+		aliases map[string]int
+	}
+	live := make(map[string]*liveSession)
+	for endpointID, conns := range endpoints {
+		if conns == 0 || endpointID == RootEndpointID {
+			continue
+		}
+		sessionID, port, alias, valid := ParseEndpointID(endpointID)
+		if !valid {
+			log.Printf("Ignoring malformed endpoint: %s", endpointID)
+			continue
+		}
+		ls, ok := live[sessionID]
+		if !ok {
+			ls = &liveSession{aliases: make(map[string]int)}
+			live[sessionID] = ls
+		}
+		if port > 0 {
+			ls.ports = append(ls.ports, port)
+		}
+		if alias != "" {
+			ls.aliases[alias] = port
+		}
+	}
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	now := time.Now()
+	for id, ls := range live {
+		session := m.getOrCreate(id)
+		if !session.Connected {
+			session.Connected = true
+			session.ConnectedAt = now
+			session.LastSeen = now
+			log.Printf("Session connected: %s", id)
+		}
+		sort.Ints(ls.ports)
+		session.AttachPorts = ls.ports
+		session.PortAliases = ls.aliases
+	}
+
 	for id, session := range m.sessions {
-		session.mu.RLock()
-		if now.Sub(session.LastSeen) > timeout {
+		if _, ok := live[id]; !ok && session.Connected {
+			session.Connected = false
+			session.AttachPorts = nil
+			session.PortAliases = nil
+			// The next client registers its own ports and gets its own token
+			session.PublicPorts = nil
+			session.TCPPorts = nil
+			session.registered = false
+			session.LastSeen = now
+			log.Printf("Session disconnected: %s", id)
+		}
+	}
+}
+
+// ResolvePortAlias returns the attached port registered under an alias
+func (m *Manager) ResolvePortAlias(id, alias string) (int, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	session, exists := m.sessions[id]
+	if !exists {
+		return 0, false
+	}
+	port, ok := session.PortAliases[alias]
+	return port, ok
+}
+
+// PortAlias returns the alias an attached port was registered under, "" if none
+func (m *Manager) PortAlias(id string, port int) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if session, exists := m.sessions[id]; exists {
+		for alias, p := range session.PortAliases {
+			if p == port {
+				return alias
+			}
+		}
+	}
+	return ""
+}
+
+// PortEndpointID returns the piko endpoint ID serving an attached port
+func (m *Manager) PortEndpointID(id string, port int) string {
+	return FormatEndpointID(id, port, m.PortAlias(id, port))
+}
+
+// Cleanup removes disconnected sessions not seen within timeout and returns their IDs
+func (m *Manager) Cleanup(timeout time.Duration) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var removed []string
+	now := time.Now()
+	for id, session := range m.sessions {
+		if !session.Connected && now.Sub(session.LastSeen) > timeout {
 			delete(m.sessions, id)
+			removed = append(removed, id)
 		}
-		session.mu.RUnlock()
 	}
+	return removed
+}
+
+// sessionIDPattern restricts session IDs so endpoint IDs split unambiguously:
+// "-" only ever separates the session ID from an attached port.
+// Letters are lowercase because session subdomains are matched lowercased.
+var sessionIDPattern = regexp.MustCompile(`^[a-z0-9_]{1,64}$`)
+
+// ValidSessionID reports whether id can be used as a session ID
+func ValidSessionID(id string) bool {
+	return sessionIDPattern.MatchString(id)
+}
+
+// ParseEndpointID splits a piko endpoint ID into session ID, attached port and port alias
+// "abc12" -> ("abc12", 0, ""), "abc12-3000" -> ("abc12", 3000, ""),
+// "abc12-3000_web" -> ("abc12", 3000, "web"). ok is false for malformed IDs.
+func ParseEndpointID(endpointID string) (sessionID string, port int, alias string, ok bool) {
+	sessionID, rest, hasPort := strings.Cut(endpointID, "-")
+	if !ValidSessionID(sessionID) {
+		return "", 0, "", false
+	}
+	if !hasPort {
+		return sessionID, 0, "", true
+	}
+	portPart, alias, _ := strings.Cut(rest, "_")
+	port, err := strconv.Atoi(portPart)
+	if err != nil || port <= 0 || port > 65535 || strconv.Itoa(port) != portPart {
+		return "", 0, "", false
+	}
+	return sessionID, port, alias, true
+}
+
+// FormatEndpointID builds the piko endpoint ID of an attached port, the inverse of ParseEndpointID
+func FormatEndpointID(sessionID string, port int, alias string) string {
+	if alias == "" {
+		return fmt.Sprintf("%s-%d", sessionID, port)
+	}
+	return fmt.Sprintf("%s-%d_%s", sessionID, port, alias)
+}
+
+// copy returns a deep copy of the session
+func (s *Session) copy() Session {
+	c := *s
+	c.AttachPorts = append([]int(nil), s.AttachPorts...)
+	c.PublicPorts = append([]int(nil), s.PublicPorts...)
+	c.TCPPorts = append([]int(nil), s.TCPPorts...)
+	c.PortAliases = make(map[string]int, len(s.PortAliases))
+	for alias, port := range s.PortAliases {
+		c.PortAliases[alias] = port
+	}
+	c.ShareTokens = make(map[int]string, len(s.ShareTokens))
+	for port, token := range s.ShareTokens {
+		c.ShareTokens[port] = token
+	}
+	c.Metadata = make(map[string]interface{}, len(s.Metadata))
+	for k, v := range s.Metadata {
+		c.Metadata[k] = v
+	}
+	return c
+}
+
+// newToken generates a random session token
+func newToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
 }
@@ -0,0 +1,76 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// EndpointWatcher polls the piko admin API and keeps the manager in sync
+// with the endpoints currently connected to the upstream port
+type EndpointWatcher struct {
+	manager    *Manager
+	statusURL  string
+	interval   time.Duration
+	httpClient *http.Client
+}
+
+// NewEndpointWatcher creates a new endpoint watcher
+// adminAddr is the piko admin address, e.g. "127.0.0.1:7070"
+func NewEndpointWatcher(manager *Manager, adminAddr string, interval time.Duration) *EndpointWatcher {
+	return &EndpointWatcher{
+		manager:   manager,
+		statusURL: fmt.Sprintf("http://%s/status/upstream/endpoints", adminAddr),
+		interval:  interval,
+		httpClient: &http.Client{
+			Timeout: 5 * time.Second,
+		},
+	}
+}
+
+// Run polls until the context is cancelled
+func (w *EndpointWatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			endpoints, err := w.fetchEndpoints(ctx)
+			if err != nil {
+				log.Printf("Failed to fetch piko endpoints: %v", err)
+				continue
+			}
+			w.manager.SyncEndpoints(endpoints)
+		}
+	}
+}
+
+// fetchEndpoints returns endpoint ID -> number of upstream connections
+func (w *EndpointWatcher) fetchEndpoints(ctx context.Context) (map[string]int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, w.statusURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("piko admin returned status %d", resp.StatusCode)
+	}
+
+	var endpoints map[string]int
+	if err := json.NewDecoder(resp.Body).Decode(&endpoints); err != nil {
+		return nil, fmt.Errorf("failed to decode endpoints: %w", err)
+	}
+	return endpoints, nil
+}